@@ -5,6 +5,7 @@ go 1.25.5
 require (
 	github.com/spf13/cobra v1.10.1
 	go.bytebuilders.dev/license-verifier/kubernetes v0.14.10
+	golang.org/x/sync v0.19.0
 	gomodules.xyz/flags v0.1.3
 	gomodules.xyz/logs v0.0.7
 	gomodules.xyz/sets v0.2.1
@@ -37,7 +38,6 @@ require (
 	github.com/x448/float16 v0.8.4 // indirect
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	go.yaml.in/yaml/v3 v3.0.4 // indirect
-	golang.org/x/sync v0.19.0 // indirect
 	gopkg.in/evanphx/json-patch.v4 v4.13.0 // indirect
 	sigs.k8s.io/randfill v1.0.0 // indirect
 	sigs.k8s.io/structured-merge-diff/v6 v6.3.0 // indirect
@@ -18,7 +18,10 @@ package pkg
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
@@ -35,9 +38,20 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
 	v1 "kmodules.xyz/offshoot-api/api/v1"
 )
 
+const (
+	// ConditionResourcesDumped is set to false when some resources have been skipped during the dump
+	ConditionResourcesDumped = "ResourcesDumped"
+	ReasonPartialDump        = "PartialDump"
+
+	// TargetNamespacesAnnotation is the annotation of the backup invoker that lists the comma separated namespaces
+	// to backup together. The --target-namespaces flag takes precedence over it.
+	TargetNamespacesAnnotation = "kubedump.stash.appscode.com/namespaces"
+)
+
 func NewCmdBackup() *cobra.Command {
 	var (
 		masterURL      string
@@ -77,15 +91,30 @@ func NewCmdBackup() *cobra.Command {
 				return err
 			}
 
+			opt.signingKey, err = opt.loadSigningKey()
+			if err != nil {
+				return err
+			}
+			opt.encryptionKey, err = opt.loadEncryptionKey()
+			if err != nil {
+				return err
+			}
+
 			inv, err := invoker.NewBackupInvoker(opt.stashClient, opt.invokerKind, opt.invokerName, opt.namespace)
 			if err != nil {
 				return err
 			}
+			if v, ok := inv.GetObjectMeta().Annotations[TargetNamespacesAnnotation]; ok && len(opt.targetNamespaces) == 0 {
+				opt.targetNamespaces = splitList(v)
+			}
 
 			for _, ti := range inv.GetTargetInfo() {
 				if ti.Target != nil && opt.targetMatched(ti.Target.Ref, opt.targetRef) {
-					var backupOutput *restic.BackupOutput
-					backupOutput, err = opt.backupResources(ti.Target.Ref)
+					var (
+						backupOutput *restic.BackupOutput
+						report       *manager.BackupReport
+					)
+					backupOutput, report, err = opt.backupResources(ti.Target.Ref)
 					if err != nil {
 						backupOutput = &restic.BackupOutput{
 							BackupTargetStatus: v1beta1.BackupTargetStatus{
@@ -102,6 +131,11 @@ func NewCmdBackup() *cobra.Command {
 					}
 					// If output directory specified, then write the output in "output.json" file in the specified directory
 					if opt.outputDir != "" {
+						if report != nil {
+							if err := writeJSONFile(filepath.Join(opt.outputDir, manager.BackupReportFileName), report); err != nil {
+								return err
+							}
+						}
 						return backupOutput.WriteOutput(filepath.Join(opt.outputDir, restic.DefaultOutputFileName))
 					}
 				}
@@ -145,24 +179,23 @@ func NewCmdBackup() *cobra.Command {
 
 	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
 
-	cmd.Flags().BoolVar(&opt.sanitize, "sanitize", true, "Specify whether to remove the decorators from the resource YAML (default is true)")
-	cmd.Flags().StringVar(&opt.selector, "label-selector", "", "Specify a label selector to filter the resources.")
-	cmd.Flags().BoolVar(&opt.includeDependants, "include-dependants", false, "Specify whether to backup the dependants object along with their parent.")
-	cmd.Flags().StringSliceVar(&opt.ignoreGroupKinds, "ignore-groupkinds", opt.ignoreGroupKinds, "Specify the groupkinds to ignore.")
+	opt.addManagerFlags(cmd)
+	opt.addSigningFlags(cmd)
+	opt.addEncryptionFlags(cmd)
 
 	return cmd
 }
 
-func (opt *options) backupResources(targetRef v1beta1.TargetRef) (*restic.BackupOutput, error) {
+func (opt *options) backupResources(targetRef v1beta1.TargetRef) (*restic.BackupOutput, *manager.BackupReport, error) {
 	var err error
 	err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// if any pre-backup actions has been assigned to it, execute them
@@ -175,42 +208,54 @@ func (opt *options) backupResources(targetRef v1beta1.TargetRef) (*restic.Backup
 	}
 	err = api_util.ExecutePreBackupActions(actionOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// wait until the backend repository has been initialized.
 	err = api_util.WaitForBackendRepository(actionOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// apply nice, ionice settings from env
 	opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	klog.Infoln("Cleaning up directory: ", opt.dataDir)
+	// the objects of the previous dump in the scratch directory are only rewritten if they have changed
 	opt.dataDir = filepath.Join(opt.setupOptions.ScratchDir, "resources")
-	if err := clearDir(opt.dataDir); err != nil {
-		return nil, err
+	// the files of a directory without a manifest can't be tracked
+	if _, err := os.Stat(filepath.Join(opt.dataDir, manager.ManifestFileName)); err != nil {
+		klog.Infoln("Cleaning up directory: ", opt.dataDir)
+		if err := clearDir(opt.dataDir); err != nil {
+			return nil, nil, err
+		}
+	}
+	storage, err := manager.NewIncrementalWriter(opt.dataDir)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	mgOpts := manager.BackupOptions{
-		Config:            opt.config,
-		Sanitize:          opt.sanitize,
-		DataDir:           opt.dataDir,
-		Target:            targetRef,
-		Selector:          opt.selector,
-		IncludeDependants: opt.includeDependants,
-		IgnoreGroupKinds:  opt.ignoreGroupKinds,
-		Storage:           manager.NewFileWriter(),
+	mgrOpts := opt.managerOptions(targetRef)
+	mgrOpts.Storage = storage
+	report, err := manager.NewBackupManager(mgrOpts).Dump()
+	if err != nil {
+		return nil, nil, err
+	}
+	if report.Changes, err = storage.Finish(); err != nil {
+		return nil, nil, err
+	}
+	klog.Infof("Dumped %d objects (%d bytes) in %s", report.TotalObjects, report.TotalBytes, report.Duration)
+	logChanges(report.Changes)
+	if c := report.Consistency; c != nil && len(c.ChangedObjects) > 0 {
+		klog.Warningf("%d objects have been modified after resource version %s during the dump", len(c.ChangedObjects), c.ResourceVersion)
 	}
-	mgr := manager.NewBackupManager(mgOpts)
-	if err = mgr.Dump(); err != nil {
-		return nil, err
+	// store the report inside the snapshot so that it describes what the snapshot contains
+	if err := writeJSONFile(filepath.Join(opt.dataDir, manager.BackupReportFileName), report); err != nil {
+		return nil, nil, err
 	}
 
 	// dumped data has been stored in the interim data dir. Now, we will backup this directory using Stash.
@@ -219,14 +264,42 @@ func (opt *options) backupResources(targetRef v1beta1.TargetRef) (*restic.Backup
 	// init restic wrapper
 	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	err = resticWrapper.EnsureNoExclusiveLock(opt.kubeClient, opt.namespace)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return resticWrapper.RunBackup(opt.backupOptions, targetRef)
+	backupOutput, err := resticWrapper.RunBackup(opt.backupOptions, targetRef)
+	if err != nil {
+		return nil, report, err
+	}
+	if len(report.Warnings) > 0 {
+		backupOutput.BackupTargetStatus.Conditions = append(backupOutput.BackupTargetStatus.Conditions, partialDumpCondition(report.Warnings))
+	}
+	return backupOutput, report, nil
+}
+
+func logChanges(c *manager.Changelog) {
+	klog.Infof("Changes since the previous dump: %d added, %d modified, %d deleted, %d unchanged",
+		len(c.Added), len(c.Modified), len(c.Deleted), c.Unchanged)
+}
+
+// partialDumpCondition reports the API groups and resources that have been skipped during the dump.
+func partialDumpCondition(warnings []manager.DumpWarning) kmapi.Condition {
+	msgs := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		msgs = append(msgs, w.String())
+	}
+	return kmapi.Condition{
+		Type:               ConditionResourcesDumped,
+		Status:             metav1.ConditionFalse,
+		Severity:           kmapi.ConditionSeverityWarning,
+		LastTransitionTime: metav1.Now(),
+		Reason:             ReasonPartialDump,
+		Message:            fmt.Sprintf("Skipped %d API groups/resources: %s", len(warnings), strings.Join(msgs, "; ")),
+	}
 }
 
 func (opt *options) targetMatched(tref v1beta1.TargetRef, expected v1beta1.TargetRef) bool {
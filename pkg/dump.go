@@ -0,0 +1,140 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"stash.appscode.dev/kubedump/pkg/manager"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+func NewCmdDump() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		output         string
+		opt            options
+	)
+
+	cmd := &cobra.Command{
+		Use:               "dump",
+		Short:             "Dumps Kubernetes resources into a local directory or archive",
+		Long:              "Dumps Kubernetes resources into a local directory or a .tar.gz archive. It does not require Stash, restic or a license.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "output")
+
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				return err
+			}
+			opt.config = config
+
+			opt.signingKey, err = opt.loadSigningKey()
+			if err != nil {
+				return err
+			}
+			opt.encryptionKey, err = opt.loadEncryptionKey()
+			if err != nil {
+				return err
+			}
+			return opt.dumpResources(output)
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&output, "output", output, "Directory or .tar.gz archive where the resources will be dumped")
+
+	cmd.Flags().StringVar(&opt.targetRef.APIVersion, "target-api-version", opt.targetRef.APIVersion, "API version of the Target")
+	cmd.Flags().StringVar(&opt.targetRef.Kind, "target-kind", opt.targetRef.Kind, "Kind of the Target (keep empty to dump the whole cluster)")
+	cmd.Flags().StringVar(&opt.targetRef.Name, "target-name", opt.targetRef.Name, "Name of the Target")
+	cmd.Flags().StringVar(&opt.targetRef.Namespace, "target-namespace", opt.targetRef.Namespace, "Namespace of the Target")
+
+	opt.addManagerFlags(cmd)
+	opt.addSigningFlags(cmd)
+	opt.addEncryptionFlags(cmd)
+
+	return cmd
+}
+
+func (opt *options) dumpResources(output string) error {
+	if !isArchive(output) {
+		opt.dataDir = output
+		if err := os.MkdirAll(opt.dataDir, os.ModePerm); err != nil {
+			return err
+		}
+		klog.Infoln("Dumping resources into directory:", opt.dataDir)
+		storage, err := manager.NewIncrementalWriter(opt.dataDir)
+		if err != nil {
+			return err
+		}
+		mgOpts := opt.managerOptions(opt.targetRef)
+		mgOpts.Storage = storage
+		report, err := manager.NewBackupManager(mgOpts).Dump()
+		if err != nil {
+			return err
+		}
+		if report.Changes, err = storage.Finish(); err != nil {
+			return err
+		}
+		logChanges(report.Changes)
+		return writeJSONFile(filepath.Join(opt.dataDir, manager.BackupReportFileName), report)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	klog.Infoln("Dumping resources into archive:", output)
+	storage := manager.NewTarWriter(f)
+	mgOpts := opt.managerOptions(opt.targetRef)
+	// the items are stored relative to the root of the archive
+	mgOpts.DataDir = ""
+	mgOpts.Storage = storage
+	report, err := manager.NewBackupManager(mgOpts).Dump()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.Write(manager.BackupReportFileName, data); err != nil {
+		return err
+	}
+	if err := storage.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func isArchive(output string) bool {
+	return strings.HasSuffix(output, ".tar.gz") || strings.HasSuffix(output, ".tgz")
+}
@@ -0,0 +1,39 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// EncryptionKeySecretKey is the key of the encryption Secret that holds the 32 bytes long key used to encrypt
+// the data of the dumped Secrets (i.e. generated by openssl rand 32).
+const EncryptionKeySecretKey = "encryption.key"
+
+// addEncryptionFlags registers the flags of the Secret that holds the key used to encrypt the data of the dumped Secrets.
+func (opt *options) addEncryptionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opt.encryptionSecret.Name, "encryption-secret-name", opt.encryptionSecret.Name, "Specify the name of the Secret that holds the AES-256 key used to encrypt the data of the dumped Secrets in its "+EncryptionKeySecretKey+" key.")
+	cmd.Flags().StringVar(&opt.encryptionSecret.Namespace, "encryption-secret-namespace", opt.encryptionSecret.Namespace, "Specify the namespace of the encryption Secret (defaults to the namespace of the Backup/Restore Session, or default).")
+}
+
+// loadEncryptionKey reads the encryption key from the encryption Secret. It returns nil if no Secret has been specified.
+func (opt *options) loadEncryptionKey() ([]byte, error) {
+	if opt.encryptionSecret.Name == "" {
+		return nil, nil
+	}
+	return opt.readSecretKey(opt.encryptionSecret, EncryptionKeySecretKey)
+}
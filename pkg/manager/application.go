@@ -18,11 +18,16 @@ package manager
 
 import (
 	"context"
+	"crypto/ed25519"
+	"fmt"
 	"path/filepath"
+	"sort"
+	"sync"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	"stash.appscode.dev/kubedump/pkg/sanitizers"
 
+	"gomodules.xyz/sets"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -31,6 +36,7 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
 )
 
 type applicationBackupManager struct {
@@ -42,7 +48,36 @@ type applicationBackupManager struct {
 	selector          string
 	includeDependants bool
 	ignoreGroupKinds  []string
+	includeResources  []string
+	excludeResources  []string
 	target            v1beta1.TargetRef
+	concurrency       int
+	strict            bool
+	// includeReferences dumps the objects referenced by the specs of the dumped objects
+	includeReferences bool
+	// appSelector is the label selector of the components of a label based application
+	appSelector string
+	stats       *backupStats
+	// dumped are the UIDs of the dumped objects
+	dumped sets.String
+	// lists caches the listed objects by the resource and the namespace
+	lists map[resourceTask][]unstructured.Unstructured
+	// resourceFilter selects the referenced objects to dump
+	resourceFilter *resourceFilter
+	graph          *applicationGraph
+	// ancestors are the UIDs of the objects being dumped along the current path of the tree
+	ancestors sets.String
+	// treeWarnings are the warnings for the owner reference cycles and, when not strict, for the
+	// referenced objects that can't be read
+	treeWarnings []DumpWarning
+	// consistencyMode is the resourceVersionMatch used to list the resources
+	consistencyMode string
+	consistency     *consistency
+	signingKey      ed25519.PrivateKey
+	index           *dumpIndex
+	encryptionKey   []byte
+	encryptor       *secretEncryptor
+	secretPolicy    sanitizers.SecretPolicy
 }
 
 func newApplicationBackupManager(opt BackupOptions) BackupManager {
@@ -54,40 +89,113 @@ func newApplicationBackupManager(opt BackupOptions) BackupManager {
 		selector:          opt.Selector,
 		includeDependants: opt.IncludeDependants,
 		ignoreGroupKinds:  opt.IgnoreGroupKinds,
+		includeResources:  opt.IncludeResources,
+		excludeResources:  opt.ExcludeResources,
 		target:            opt.Target,
+		concurrency:       opt.Concurrency,
+		strict:            opt.Strict,
+		includeReferences: opt.IncludeReferences,
+		appSelector:       opt.AppSelector,
+		consistencyMode:   opt.Consistency,
+		signingKey:        opt.SigningKey,
+		encryptionKey:     opt.EncryptionKey,
+		secretPolicy:      opt.SecretPolicy,
 	}
 }
 
-func (opt applicationBackupManager) Dump() error {
-	gvr, err := opt.getRootObjectGVR()
+func (opt applicationBackupManager) Dump() (*BackupReport, error) {
+	opt.stats = newBackupStats()
+	opt.dumped = sets.NewString()
+	opt.graph = newApplicationGraph()
+	opt.index = newDumpIndex(opt.dataDir)
+	opt.ancestors = sets.NewString()
+	opt.lists = make(map[resourceTask][]unstructured.Unstructured)
+	var err error
+	opt.resourceFilter, err = newResourceFilter(opt.includeResources, opt.excludeResources)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	rootObj, err := opt.getRootObject(*gvr)
+	opt.encryptor, err = newSecretEncryptor(opt.encryptionKey)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := opt.secretPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	opt.di, err = dynamic.NewForConfig(opt.config)
+	if err != nil {
+		return nil, err
+	}
+	opt.consistency, err = newConsistency(opt.consistencyMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := opt.consistency.start(opt.di, opt.target.Namespace); err != nil {
+		return nil, err
 	}
 
-	rTree := treeBuilder{
+	rTree := &treeBuilder{
 		resourceTree: make(map[types.UID][]resourceRef),
 	}
-	rootUID := types.UID("root")
-	rTree.resourceTree[rootUID] = []resourceRef{
-		{
-			gvr:       *gvr,
-			name:      rootObj.GetName(),
-			namespace: rootObj.GetNamespace(),
-			kind:      rootObj.GetKind(),
-		},
+	var (
+		rootUID  types.UID
+		warnings []DumpWarning
+	)
+	if opt.appSelector != "" {
+		// the components of a label based application have no common root. They are stored like the dependants of a root.
+		rootUID = componentsUID
+		rTree.resourceTree[rootUID], warnings, err = opt.collectComponents(opt.target.Namespace, opt.appSelector, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		gvr, err := opt.getRootObjectGVR()
+		if err != nil {
+			return nil, err
+		}
+		rootObj, err := opt.getRootObject(*gvr)
+		if err != nil {
+			return nil, err
+		}
+		opt.consistency.check([]unstructured.Unstructured{*rootObj}, *gvr)
+
+		rootUID = "root"
+		rTree.resourceTree[rootUID] = []resourceRef{
+			{
+				gvr:       *gvr,
+				name:      rootObj.GetName(),
+				namespace: rootObj.GetNamespace(),
+				kind:      rootObj.GetKind(),
+				obj:       rootObj,
+			},
+		}
+		if isApplicationCR(rootObj) {
+			rTree.resourceTree[rootObj.GetUID()], warnings, err = opt.applicationComponents(rootObj)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if opt.includeDependants {
-		err := opt.generateDependencyTree(&rTree)
+		dependantWarnings, err := opt.generateDependencyTree(rTree, rTree.resourceTree[rootUID])
 		if err != nil {
-			return err
+			return nil, err
 		}
+		warnings = append(warnings, dependantWarnings...)
+	}
+	if err := opt.dumpResourceTree(rTree.resourceTree, rootUID, opt.dataDir); err != nil {
+		return nil, err
+	}
+	if err := opt.writeGraph(); err != nil {
+		return nil, err
+	}
+	if err := opt.index.write(opt.storage, opt.signingKey); err != nil {
+		return nil, err
 	}
-	return opt.dumpResourceTree(rTree.resourceTree, rootUID, opt.dataDir)
+	report := opt.stats.report(append(warnings, opt.treeWarnings...))
+	report.Consistency = opt.consistency.report()
+	return report, nil
 }
 
 func (opt *applicationBackupManager) getRootObjectGVR() (*schema.GroupVersionResource, error) {
@@ -115,24 +223,63 @@ func (opt *applicationBackupManager) getRootObjectGVR() (*schema.GroupVersionRes
 }
 
 func (opt *applicationBackupManager) getRootObject(gvr schema.GroupVersionResource) (*unstructured.Unstructured, error) {
-	var err error
-	opt.di, err = dynamic.NewForConfig(opt.config)
-	if err != nil {
-		return nil, err
-	}
 	ri := opt.di.Resource(gvr).Namespace(opt.target.Namespace)
 	return ri.Get(context.TODO(), opt.target.Name, metav1.GetOptions{})
 }
 
-func (opt *applicationBackupManager) generateDependencyTree(tb *treeBuilder) error {
+// generateDependencyTree finds the dependants of the roots level by level. At each level, only the resources
+// that can be owned by the kinds of the previous level are listed, and every resource is listed at most once.
+func (opt *applicationBackupManager) generateDependencyTree(tb *treeBuilder, roots []resourceRef) ([]DumpWarning, error) {
 	rp := resourceProcessor{
 		config:           opt.config,
 		namespace:        opt.target.Namespace,
 		selector:         opt.selector,
 		itemProcessor:    tb,
 		ignoreGroupKinds: opt.ignoreGroupKinds,
+		includeResources: opt.includeResources,
+		excludeResources: opt.excludeResources,
+		concurrency:      opt.concurrency,
+		strict:           opt.strict,
+		stats:            opt.stats,
+		consistency:      opt.consistency,
+	}
+	tasks, err := rp.discoverResources()
+	if err != nil {
+		return nil, err
 	}
-	return rp.processAPIResources()
+
+	listed := make([]bool, len(tasks))
+	visited := sets.NewString()
+	for level := roots; len(level) > 0; {
+		owners := make(map[schema.GroupKind]bool)
+		for _, r := range level {
+			owners[schema.GroupKind{Group: r.gvr.Group, Kind: r.kind}] = true
+		}
+
+		var pending []resourceTask
+		for i, t := range tasks {
+			if !listed[i] && canBeOwned(schema.GroupKind{Group: t.gvr.Group, Kind: t.kind}, owners) {
+				listed[i] = true
+				pending = append(pending, t)
+			}
+		}
+		if err := rp.processResources(pending); err != nil {
+			return nil, err
+		}
+
+		var next []resourceRef
+		for _, r := range level {
+			uid := r.obj.GetUID()
+			if visited.Has(string(uid)) {
+				continue
+			}
+			visited.Insert(string(uid))
+			next = append(next, tb.children(uid)...)
+		}
+		level = next
+	}
+	tb.sort()
+	return rp.warnings.list(), nil
 }
 
 type resourceRef struct {
@@ -140,13 +287,20 @@ type resourceRef struct {
 	name      string
 	namespace string
 	kind      string
+	// obj is the listed object. It is nil if the object has to be fetched.
+	obj *unstructured.Unstructured
 }
 
+// treeBuilder groups the resources by the UIDs of their owners. It is safe for concurrent use.
 type treeBuilder struct {
+	mu           sync.Mutex
 	resourceTree map[types.UID][]resourceRef
 }
 
-func (opt treeBuilder) Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+func (opt *treeBuilder) Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+
 	for _, r := range items {
 		ownerRefs := r.GetOwnerReferences()
 		for i := range ownerRefs {
@@ -158,56 +312,169 @@ func (opt treeBuilder) Process(items []unstructured.Unstructured, gvr schema.Gro
 				name:      r.GetName(),
 				namespace: r.GetNamespace(),
 				kind:      r.GetKind(),
+				obj:       &r,
 			})
 		}
 	}
 	return nil
 }
 
-func (opt *applicationBackupManager) dumpResourceTree(resourceTree map[types.UID][]resourceRef, rootUID types.UID, prefix string) error {
-	for _, r := range resourceTree[rootUID] {
-		childUID, err := opt.dumpItem(r, prefix)
+func (opt *treeBuilder) children(uid types.UID) []resourceRef {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+	return opt.resourceTree[uid]
+}
+
+// sort orders the dependants of every owner so that the dump does not depend on the order the resources were listed in.
+func (opt *treeBuilder) sort() {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+
+	for _, refs := range opt.resourceTree {
+		sortResourceRefs(refs)
+	}
+}
+
+func sortResourceRefs(refs []resourceRef) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].gvr != refs[j].gvr {
+			return refs[i].gvr.String() < refs[j].gvr.String()
+		}
+		if refs[i].namespace != refs[j].namespace {
+			return refs[i].namespace < refs[j].namespace
+		}
+		return refs[i].name < refs[j].name
+	})
+}
+
+func (opt *applicationBackupManager) dumpResourceTree(resourceTree map[types.UID][]resourceRef, ownerUID types.UID, prefix string) error {
+	for _, r := range resourceTree[ownerUID] {
+		obj, err := opt.getObject(r)
 		if err != nil {
 			return err
 		}
 		childPrefix := prefix
-		if rootUID != "root" {
+		if ownerUID != "root" {
 			childPrefix = filepath.Join(prefix, r.kind, r.name)
 		}
-		err = opt.dumpResourceTree(resourceTree, childUID, childPrefix)
-		if err != nil {
+		if err := opt.dumpNode(resourceTree, ownerUID, edgeOwner, obj, r.gvr, prefix, childPrefix); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (opt *applicationBackupManager) dumpItem(r resourceRef, prefix string) (types.UID, error) {
+// dumpNode stores the object under the prefix and its subtree under the child prefix. An object that has
+// already been dumped is only linked to the object it has been reached from.
+func (opt *applicationBackupManager) dumpNode(
+	resourceTree map[types.UID][]resourceRef,
+	from types.UID,
+	edgeType string,
+	obj *unstructured.Unstructured,
+	gvr schema.GroupVersionResource,
+	prefix, childPrefix string,
+) error {
+	uid := obj.GetUID()
+	if opt.dumped.Has(string(uid)) {
+		opt.graph.addEdge(from, uid, edgeType)
+		if edgeType != edgeOwner {
+			return nil
+		}
+		if opt.ancestors.Has(string(uid)) {
+			klog.Warningf("Skipping the owner reference cycle through %s", objectKey(obj))
+			opt.treeWarnings = append(opt.treeWarnings, DumpWarning{
+				GroupVersion: gvr.GroupVersion().String(),
+				Resource:     gvr.Resource,
+				Namespace:    obj.GetNamespace(),
+				Reason:       fmt.Sprintf("%s %s is owned by its own dependant", obj.GetKind(), obj.GetName()),
+			})
+			return nil
+		}
+		opt.graph.addOwner(uid, from)
+		return nil
+	}
+
+	fileName, err := opt.storeObject(obj, gvr, prefix)
+	if err != nil || fileName == "" {
+		return err
+	}
+	opt.graph.addEdge(from, uid, edgeType)
+	opt.graph.addNode(obj, opt.relativePath(fileName), from)
+
+	opt.ancestors.Insert(string(uid))
+	defer opt.ancestors.Delete(string(uid))
+	return opt.dumpSubtree(resourceTree, obj, childPrefix)
+}
+
+// dumpSubtree dumps the objects referenced by the spec of the object, if enabled, and its dependants under the prefix.
+func (opt *applicationBackupManager) dumpSubtree(resourceTree map[types.UID][]resourceRef, obj *unstructured.Unstructured, prefix string) error {
+	if opt.includeReferences {
+		if err := opt.dumpReferences(resourceTree, obj, prefix); err != nil {
+			return err
+		}
+	}
+	return opt.dumpResourceTree(resourceTree, obj.GetUID(), prefix)
+}
+
+// getObject returns the listed object or fetches it.
+func (opt *applicationBackupManager) getObject(r resourceRef) (*unstructured.Unstructured, error) {
+	if r.obj != nil {
+		return r.obj, nil
+	}
+
 	var ri dynamic.ResourceInterface
 	if r.namespace != "" {
 		ri = opt.di.Resource(r.gvr).Namespace(r.namespace)
 	} else {
 		ri = opt.di.Resource(r.gvr)
 	}
-
 	obj, err := ri.Get(context.TODO(), r.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	opt.consistency.check([]unstructured.Unstructured{*obj}, r.gvr)
+	return obj, nil
+}
+
+// storeObject stores the object under the prefix and returns the name of the file. It returns an empty
+// name if the object is skipped by the Secret policy.
+func (opt *applicationBackupManager) storeObject(obj *unstructured.Unstructured, gvr schema.GroupVersionResource, prefix string) (string, error) {
+	data, err := sanitizeObject(obj.DeepCopy().Object, obj.GetKind(), opt.sanitize, opt.secretPolicy)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		opt.stats.recordSkipped(gvr)
+		return "", nil
+	}
+	fileName := opt.getFileName(obj, prefix)
+	data, err = opt.encryptor.encryptItem(data, fileName, opt.storage)
+	if err != nil {
+		return "", err
+	}
+	out, err := storeItem(fileName, data, opt.storage)
 	if err != nil {
 		return "", err
 	}
+	opt.dumped.Insert(string(obj.GetUID()))
+	opt.stats.recordDumped(gvr, len(out))
+	opt.index.record(newIndexEntry(obj), fileName, out)
+	return fileName, nil
+}
 
-	data := obj.Object
-	uid := obj.GetUID()
-	if opt.sanitize {
-		s := sanitizers.NewSanitizer(obj.GetKind())
-		data, err = s.Sanitize(data)
-		if err != nil {
-			return "", err
-		}
-		delete(data, "status")
+func (opt *applicationBackupManager) relativePath(fileName string) string {
+	if rel, err := filepath.Rel(opt.dataDir, fileName); err == nil {
+		return filepath.ToSlash(rel)
 	}
+	return filepath.ToSlash(fileName)
+}
 
-	fileName := opt.getFileName(obj, prefix)
-	return uid, storeItem(fileName, data, opt.storage)
+func (opt *applicationBackupManager) writeGraph() error {
+	data, err := opt.graph.marshal()
+	if err != nil {
+		return err
+	}
+	return opt.storage.Write(filepath.Join(opt.dataDir, ApplicationGraphFileName), data)
 }
 
 func (opt *applicationBackupManager) getFileName(r *unstructured.Unstructured, prefix string) string {
@@ -0,0 +1,133 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// componentsUID is the pseudo owner of the components of a label based application.
+const componentsUID types.UID = "components"
+
+// applicationGroupKind is the kind of the sig-apps Application resource that lists the components of an application.
+var applicationGroupKind = schema.GroupKind{Group: "app.k8s.io", Kind: "Application"}
+
+func isApplicationCR(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == applicationGroupKind
+}
+
+// applicationComponents returns the components of a sig-apps Application, i.e. the objects of its
+// spec.componentKinds in its namespace that match its spec.selector.
+func (opt *applicationBackupManager) applicationComponents(app *unstructured.Unstructured) ([]resourceRef, []DumpWarning, error) {
+	selector, kinds, ok, err := componentQuery(app)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	return opt.collectComponents(app.GetNamespace(), selector, kinds)
+}
+
+// componentQuery returns the label selector of the components of a sig-apps Application and the resource
+// patterns of their kinds. It returns false if the Application has no selector or no component kinds.
+func componentQuery(app *unstructured.Unstructured) (string, []string, bool, error) {
+	m, ok, _ := unstructured.NestedMap(app.Object, "spec", "selector")
+	if !ok {
+		klog.Warningf("Application %s/%s has no selector, only the Application itself will be dumped", app.GetNamespace(), app.GetName())
+		return "", nil, false, nil
+	}
+	var ls metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ls); err != nil {
+		return "", nil, false, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&ls)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	var kinds []string
+	for _, ck := range nestedMaps(app.Object, "spec", "componentKinds") {
+		group, _, _ := unstructured.NestedString(ck, "group")
+		kind, _, _ := unstructured.NestedString(ck, "kind")
+		if kind == "" {
+			continue
+		}
+		if group == "" {
+			group = "core"
+		}
+		// matches the kind in the group as described in resourcePattern
+		kinds = append(kinds, group+"/"+kind)
+	}
+	if len(kinds) == 0 {
+		return "", nil, false, nil
+	}
+	return selector.String(), kinds, true, nil
+}
+
+// collectComponents returns the objects matching the label selector in the namespace, or in the whole cluster
+// if the namespace is empty. Only the top level objects are returned, the objects managed by a controller are
+// expected to be collected as dependants. If kinds are given, only the objects matching them and the resource
+// filters are returned.
+func (opt *applicationBackupManager) collectComponents(namespace, selector string, kinds []string) ([]resourceRef, []DumpWarning, error) {
+	cc := &componentCollector{}
+	rp := resourceProcessor{
+		config:            opt.config,
+		namespace:         namespace,
+		selector:          selector,
+		itemProcessor:     cc,
+		ignoreGroupKinds:  opt.ignoreGroupKinds,
+		includeResources:  opt.includeResources,
+		excludeResources:  opt.excludeResources,
+		requiredResources: kinds,
+		concurrency:       opt.concurrency,
+		strict:            opt.strict,
+		stats:             opt.stats,
+		consistency:       opt.consistency,
+	}
+	warnings, err := rp.processAPIResources()
+	if err != nil {
+		return nil, nil, err
+	}
+	sortResourceRefs(cc.components)
+	return cc.components, warnings, nil
+}
+
+// componentCollector collects the top level objects. It is safe for concurrent use.
+type componentCollector struct {
+	mu         sync.Mutex
+	components []resourceRef
+}
+
+func (c *componentCollector) Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range items {
+		if metav1.GetControllerOf(&items[i]) != nil {
+			continue
+		}
+		ref := refOf(&items[i], gvr)
+		ref.obj = &items[i]
+		c.components = append(c.components, ref)
+	}
+	return nil
+}
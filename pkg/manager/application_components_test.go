@@ -0,0 +1,136 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_componentQuery(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         map[string]any
+		wantOK       bool
+		wantSelector string
+		wantKinds    []string
+	}{
+		{
+			name: "missing selector",
+			spec: map[string]any{
+				"componentKinds": []any{map[string]any{"group": "apps", "kind": "Deployment"}},
+			},
+		},
+		{
+			name: "empty component kinds",
+			spec: map[string]any{
+				"selector":       map[string]any{"matchLabels": map[string]any{"app": "shop"}},
+				"componentKinds": []any{},
+			},
+		},
+		{
+			name: "component kinds without kind",
+			spec: map[string]any{
+				"selector":       map[string]any{"matchLabels": map[string]any{"app": "shop"}},
+				"componentKinds": []any{map[string]any{"group": "apps"}},
+			},
+		},
+		{
+			name: "core and named groups",
+			spec: map[string]any{
+				"selector": map[string]any{
+					"matchLabels":      map[string]any{"app": "shop"},
+					"matchExpressions": []any{map[string]any{"key": "tier", "operator": "In", "values": []any{"web"}}},
+				},
+				"componentKinds": []any{
+					map[string]any{"group": "", "kind": "Service"},
+					map[string]any{"kind": "ConfigMap"},
+					map[string]any{"group": "apps", "kind": "Deployment"},
+				},
+			},
+			wantOK:       true,
+			wantSelector: "app=shop,tier in (web)",
+			wantKinds:    []string{"core/Service", "core/ConfigMap", "apps/Deployment"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &unstructured.Unstructured{Object: map[string]any{
+				"apiVersion": "app.k8s.io/v1beta1",
+				"kind":       "Application",
+				"metadata":   map[string]any{"name": "shop", "namespace": "demo"},
+				"spec":       tt.spec,
+			}}
+			selector, kinds, ok, err := componentQuery(app)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || selector != tt.wantSelector || !reflect.DeepEqual(kinds, tt.wantKinds) {
+				t.Errorf("componentQuery() = %q, %v, %v, want %q, %v, %v", selector, kinds, ok, tt.wantSelector, tt.wantKinds, tt.wantOK)
+			}
+		})
+	}
+
+	// the kinds are matched in their groups by the resource filter
+	f, err := newResourceFilter([]string{"core/Service", "apps/Deployment"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.allows(schema.GroupVersionResource{Version: "v1", Resource: "services"}, "Service") {
+		t.Error("core/Service does not match the Services")
+	}
+	if f.allows(schema.GroupVersionResource{Group: "serving.knative.dev", Version: "v1", Resource: "services"}, "Service") {
+		t.Error("core/Service matches the Services of another group")
+	}
+}
+
+func Test_componentCollector(t *testing.T) {
+	newObject := func(name string, owners ...metav1.OwnerReference) unstructured.Unstructured {
+		obj := unstructured.Unstructured{}
+		obj.SetAPIVersion("apps/v1")
+		obj.SetKind("ReplicaSet")
+		obj.SetNamespace("demo")
+		obj.SetName(name)
+		obj.SetOwnerReferences(owners)
+		return obj
+	}
+	controller := true
+	items := []unstructured.Unstructured{
+		newObject("top"),
+		newObject("controlled", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", Controller: &controller}),
+		newObject("owned", metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}),
+	}
+
+	c := &componentCollector{}
+	if err := c.Process(items, schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ref := range c.components {
+		if ref.obj == nil {
+			t.Errorf("component %s has no object", ref.name)
+		}
+		names = append(names, ref.name)
+	}
+	if want := []string{"top", "owned"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("components = %v, want %v", names, want)
+	}
+}
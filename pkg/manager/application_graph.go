@@ -0,0 +1,106 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ApplicationGraphFileName is the name of the file that describes the dumped application tree.
+// It is written into the root of the dump.
+const ApplicationGraphFileName = "graph.json"
+
+const (
+	// edgeOwner links an owner to its dependant
+	edgeOwner = "owner"
+	// edgeReference links an object to an object referenced by its spec, or that selects or scales it
+	edgeReference = "reference"
+)
+
+// applicationGraph describes the dumped objects and how they are related.
+type applicationGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges,omitempty"`
+	// nodes indexes the nodes by their UIDs
+	nodes map[types.UID]int
+}
+
+type graphNode struct {
+	UID        types.UID `json:"uid"`
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	// Path is the file the object is stored in, relative to the root of the dump
+	Path string `json:"path"`
+	// OwnerPaths are the files of the owners of the object. The object is stored only once, under the first owner.
+	OwnerPaths []string `json:"ownerPaths,omitempty"`
+}
+
+type graphEdge struct {
+	From types.UID `json:"from"`
+	To   types.UID `json:"to"`
+	Type string    `json:"type"`
+}
+
+func newApplicationGraph() *applicationGraph {
+	return &applicationGraph{
+		Nodes: make([]graphNode, 0),
+		nodes: make(map[types.UID]int),
+	}
+}
+
+// addNode records an object stored at the path. The object is owned by the object of the given UID,
+// if that object is in the graph.
+func (g *applicationGraph) addNode(obj *unstructured.Unstructured, path string, owner types.UID) {
+	g.nodes[obj.GetUID()] = len(g.Nodes)
+	g.Nodes = append(g.Nodes, graphNode{
+		UID:        obj.GetUID(),
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Path:       path,
+	})
+	g.addOwner(obj.GetUID(), owner)
+}
+
+// addOwner records the path of the owner of an object that is already in the graph.
+func (g *applicationGraph) addOwner(uid, owner types.UID) {
+	i, ok := g.nodes[owner]
+	if !ok {
+		return
+	}
+	node := &g.Nodes[g.nodes[uid]]
+	node.OwnerPaths = append(node.OwnerPaths, g.Nodes[i].Path)
+}
+
+// addEdge links two objects. The edges from the objects that are not in the graph, i.e. the pseudo
+// roots of the tree, are ignored.
+func (g *applicationGraph) addEdge(from, to types.UID, edgeType string) {
+	if _, ok := g.nodes[from]; !ok {
+		return
+	}
+	g.Edges = append(g.Edges, graphEdge{From: from, To: to, Type: edgeType})
+}
+
+func (g *applicationGraph) marshal() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	"gomodules.xyz/sets"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type memoryWriter struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (w *memoryWriter) Write(path string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.files[path] = data
+	return nil
+}
+
+func testRef(kind, name string, uid types.UID) resourceRef {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("example.com/v1")
+	obj.SetKind(kind)
+	obj.SetNamespace("demo")
+	obj.SetName(name)
+	obj.SetUID(uid)
+	return resourceRef{
+		gvr:       schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: kind},
+		kind:      kind,
+		namespace: "demo",
+		name:      name,
+		obj:       obj,
+	}
+}
+
+func Test_dumpResourceTree(t *testing.T) {
+	root := testRef("Root", "app", "root-uid")
+	a := testRef("A", "a", "a-uid")
+	b := testRef("B", "b", "b-uid")
+	shared := testRef("Shared", "shared", "shared-uid")
+
+	// shared is owned by both a and b, while a and root own each other
+	tree := map[types.UID][]resourceRef{
+		"root":     {root},
+		"root-uid": {a, b},
+		"a-uid":    {shared, root},
+		"b-uid":    {shared},
+	}
+	w := &memoryWriter{files: map[string][]byte{}}
+	opt := applicationBackupManager{
+		storage:   w,
+		dataDir:   "/data",
+		target:    v1beta1.TargetRef{APIVersion: "example.com/v1", Kind: "Root", Namespace: "demo", Name: "app"},
+		stats:     newBackupStats(),
+		dumped:    sets.NewString(),
+		graph:     newApplicationGraph(),
+		ancestors: sets.NewString(),
+	}
+	if err := opt.dumpResourceTree(tree, "root", opt.dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	for f := range w.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	wantFiles := []string{
+		"/data/A/a/Shared/shared/shared.yaml",
+		"/data/A/a/a.yaml",
+		"/data/B/b/b.yaml",
+		"/data/app.yaml",
+	}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("files = %v, want %v", files, wantFiles)
+	}
+
+	node := opt.graph.Nodes[opt.graph.nodes["shared-uid"]]
+	if want := []string{"A/a/a.yaml", "B/b/b.yaml"}; !reflect.DeepEqual(node.OwnerPaths, want) {
+		t.Errorf("ownerPaths = %v, want %v", node.OwnerPaths, want)
+	}
+	if len(opt.treeWarnings) != 1 || opt.treeWarnings[0].Resource != "Root" {
+		t.Errorf("cycles = %v, want a single cycle through Root", opt.treeWarnings)
+	}
+	if got := len(opt.graph.Edges); got != 5 {
+		t.Errorf("got %d edges, want 5", got)
+	}
+}
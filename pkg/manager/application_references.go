@@ -0,0 +1,262 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+var (
+	configMapGVR             = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretGVR                = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	persistentVolumeClaimGVR = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	serviceAccountGVR        = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	serviceGVR               = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	hpaGVR                   = schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+	pdbGVR                   = schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}
+)
+
+// specReferences returns the objects that the pod spec of the object refers to. It returns nothing for
+// the objects without a pod spec.
+func specReferences(obj *unstructured.Unstructured) []resourceRef {
+	fields, ok := podSpecFields[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		return nil
+	}
+	podSpec, ok, _ := unstructured.NestedMap(obj.Object, fields...)
+	if !ok {
+		return nil
+	}
+
+	var refs []resourceRef
+	seen := map[resourceRef]bool{}
+	add := func(gvr schema.GroupVersionResource, kind, name string) {
+		ref := resourceRef{gvr: gvr, kind: kind, name: name, namespace: obj.GetNamespace()}
+		if name != "" && !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+
+	name, _, _ := unstructured.NestedString(podSpec, "serviceAccountName")
+	add(serviceAccountGVR, "ServiceAccount", name)
+	for _, s := range nestedMaps(podSpec, "imagePullSecrets") {
+		name, _, _ := unstructured.NestedString(s, "name")
+		add(secretGVR, "Secret", name)
+	}
+
+	for _, v := range nestedMaps(podSpec, "volumes") {
+		name, _, _ := unstructured.NestedString(v, "configMap", "name")
+		add(configMapGVR, "ConfigMap", name)
+		name, _, _ = unstructured.NestedString(v, "secret", "secretName")
+		add(secretGVR, "Secret", name)
+		name, _, _ = unstructured.NestedString(v, "persistentVolumeClaim", "claimName")
+		add(persistentVolumeClaimGVR, "PersistentVolumeClaim", name)
+		for _, src := range nestedMaps(v, "projected", "sources") {
+			name, _, _ := unstructured.NestedString(src, "configMap", "name")
+			add(configMapGVR, "ConfigMap", name)
+			name, _, _ = unstructured.NestedString(src, "secret", "name")
+			add(secretGVR, "Secret", name)
+		}
+	}
+
+	containers := append(nestedMaps(podSpec, "initContainers"), nestedMaps(podSpec, "containers")...)
+	for _, c := range containers {
+		for _, e := range nestedMaps(c, "envFrom") {
+			name, _, _ := unstructured.NestedString(e, "configMapRef", "name")
+			add(configMapGVR, "ConfigMap", name)
+			name, _, _ = unstructured.NestedString(e, "secretRef", "name")
+			add(secretGVR, "Secret", name)
+		}
+		for _, e := range nestedMaps(c, "env") {
+			name, _, _ := unstructured.NestedString(e, "valueFrom", "configMapKeyRef", "name")
+			add(configMapGVR, "ConfigMap", name)
+			name, _, _ = unstructured.NestedString(e, "valueFrom", "secretKeyRef", "name")
+			add(secretGVR, "Secret", name)
+		}
+	}
+	return refs
+}
+
+// nestedMaps returns the maps in the slice at the given path.
+func nestedMaps(obj map[string]any, fields ...string) []map[string]any {
+	items, _, _ := unstructured.NestedSlice(obj, fields...)
+	out := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// podLabels returns the labels of the pods created from the object.
+func podLabels(obj *unstructured.Unstructured) (labels.Set, bool) {
+	fields, ok := podSpecFields[obj.GroupVersionKind().GroupKind()]
+	if !ok {
+		return nil, false
+	}
+	path := append(append([]string{}, fields[:len(fields)-1]...), "metadata", "labels")
+	l, ok, _ := unstructured.NestedStringMap(obj.Object, path...)
+	return l, ok
+}
+
+// selectorReferences returns the Services and PodDisruptionBudgets that select the pods of the object and
+// the HorizontalPodAutoscalers that scale the object.
+func (opt *applicationBackupManager) selectorReferences(obj *unstructured.Unstructured) ([]resourceRef, error) {
+	var refs []resourceRef
+	if podLabels, ok := podLabels(obj); ok && len(podLabels) > 0 {
+		services, err := opt.listNamespaced(serviceGVR, obj.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range services {
+			selector, _, _ := unstructured.NestedStringMap(svc.Object, "spec", "selector")
+			if len(selector) > 0 && labels.SelectorFromSet(selector).Matches(podLabels) {
+				refs = append(refs, refOf(&svc, serviceGVR))
+			}
+		}
+
+		pdbs, err := opt.listNamespaced(pdbGVR, obj.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		for _, pdb := range pdbs {
+			m, ok, _ := unstructured.NestedMap(pdb.Object, "spec", "selector")
+			if !ok {
+				continue
+			}
+			var ls metav1.LabelSelector
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ls); err != nil {
+				return nil, err
+			}
+			selector, err := metav1.LabelSelectorAsSelector(&ls)
+			if err != nil || selector.Empty() {
+				continue
+			}
+			if selector.Matches(podLabels) {
+				refs = append(refs, refOf(&pdb, pdbGVR))
+			}
+		}
+	}
+
+	hpas, err := opt.listNamespaced(hpaGVR, obj.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	for _, hpa := range hpas {
+		target, _, _ := unstructured.NestedStringMap(hpa.Object, "spec", "scaleTargetRef")
+		gv, _ := schema.ParseGroupVersion(target["apiVersion"])
+		if target["kind"] == obj.GetKind() && target["name"] == obj.GetName() && gv.Group == obj.GroupVersionKind().Group {
+			refs = append(refs, refOf(&hpa, hpaGVR))
+		}
+	}
+	return refs, nil
+}
+
+func refOf(obj *unstructured.Unstructured, gvr schema.GroupVersionResource) resourceRef {
+	return resourceRef{gvr: gvr, name: obj.GetName(), namespace: obj.GetNamespace(), kind: obj.GetKind()}
+}
+
+// listNamespaced lists the objects of the resource in the namespace. The result is cached for the rest of the dump.
+// A resource that is not served by the cluster has no objects, nor has a resource that can't be listed when not strict.
+func (opt *applicationBackupManager) listNamespaced(gvr schema.GroupVersionResource, namespace string) ([]unstructured.Unstructured, error) {
+	key := resourceTask{gvr: gvr, namespace: namespace}
+	if items, ok := opt.lists[key]; ok {
+		return items, nil
+	}
+	list, err := opt.di.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		if opt.strict {
+			return nil, err
+		}
+		klog.Warningf("Skipping the references to resource %s as it can't be listed: %v", gvr, err)
+		opt.treeWarnings = append(opt.treeWarnings, DumpWarning{
+			GroupVersion: gvr.GroupVersion().String(),
+			Resource:     gvr.Resource,
+			Namespace:    namespace,
+			Reason:       err.Error(),
+		})
+		list = nil
+	}
+	var items []unstructured.Unstructured
+	if list != nil {
+		items = list.Items
+	}
+	opt.lists[key] = items
+	return items, nil
+}
+
+// dumpReferences dumps the objects that the object refers to by its spec or that select or scale the object.
+// Every object is dumped once, under the first object that refers to it or owns it.
+func (opt *applicationBackupManager) dumpReferences(resourceTree map[types.UID][]resourceRef, obj *unstructured.Unstructured, prefix string) error {
+	refs := specReferences(obj)
+	selected, err := opt.selectorReferences(obj)
+	if err != nil {
+		return err
+	}
+	refs = append(refs, selected...)
+
+	for _, r := range refs {
+		if opt.shouldIgnoreResource(r) {
+			continue
+		}
+		ref, err := opt.di.Resource(r.gvr).Namespace(r.namespace).Get(context.TODO(), r.name, metav1.GetOptions{})
+		if err != nil {
+			if kerr.IsNotFound(err) {
+				klog.V(5).Infof("Skipping %s %s/%s referred by %s as it does not exist", r.kind, r.namespace, r.name, objectKey(obj))
+				continue
+			}
+			if opt.strict {
+				return err
+			}
+			klog.Warningf("Skipping %s %s/%s referred by %s as it can't be read: %v", r.kind, r.namespace, r.name, objectKey(obj), err)
+			opt.treeWarnings = append(opt.treeWarnings, DumpWarning{
+				GroupVersion: r.gvr.GroupVersion().String(),
+				Resource:     r.gvr.Resource,
+				Namespace:    r.namespace,
+				Reason:       fmt.Sprintf("%s %s referred by %s: %v", r.kind, r.name, objectKey(obj), err),
+			})
+			continue
+		}
+		opt.consistency.check([]unstructured.Unstructured{*ref}, r.gvr)
+		if err := opt.dumpNode(resourceTree, obj.GetUID(), edgeReference, ref, r.gvr, prefix, filepath.Join(prefix, r.kind, r.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opt *applicationBackupManager) shouldIgnoreResource(r resourceRef) bool {
+	for _, igk := range opt.ignoreGroupKinds {
+		if (schema.GroupKind{Group: r.gvr.Group, Kind: r.kind}) == schema.ParseGroupKind(igk) {
+			return true
+		}
+	}
+	return !opt.resourceFilter.allows(r.gvr, r.kind)
+}
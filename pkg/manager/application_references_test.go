@@ -0,0 +1,244 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func Test_specReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  map[string]any
+		want []resourceRef
+	}{
+		{
+			name: "object without pod spec",
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"name": "config", "namespace": "demo"},
+			},
+		},
+		{
+			name: "pod",
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"metadata":   map[string]any{"name": "web", "namespace": "demo"},
+				"spec": map[string]any{
+					"serviceAccountName": "web",
+					"imagePullSecrets":   []any{map[string]any{"name": "registry"}},
+					"volumes": []any{
+						map[string]any{"name": "config", "configMap": map[string]any{"name": "config"}},
+						map[string]any{"name": "tls", "secret": map[string]any{"secretName": "tls"}},
+						map[string]any{"name": "data", "persistentVolumeClaim": map[string]any{"claimName": "data"}},
+						map[string]any{"name": "projected", "projected": map[string]any{"sources": []any{
+							map[string]any{"configMap": map[string]any{"name": "projected-config"}},
+							map[string]any{"secret": map[string]any{"name": "projected-secret"}},
+						}}},
+					},
+				},
+			},
+			want: []resourceRef{
+				{gvr: serviceAccountGVR, kind: "ServiceAccount", name: "web", namespace: "demo"},
+				{gvr: secretGVR, kind: "Secret", name: "registry", namespace: "demo"},
+				{gvr: configMapGVR, kind: "ConfigMap", name: "config", namespace: "demo"},
+				{gvr: secretGVR, kind: "Secret", name: "tls", namespace: "demo"},
+				{gvr: persistentVolumeClaimGVR, kind: "PersistentVolumeClaim", name: "data", namespace: "demo"},
+				{gvr: configMapGVR, kind: "ConfigMap", name: "projected-config", namespace: "demo"},
+				{gvr: secretGVR, kind: "Secret", name: "projected-secret", namespace: "demo"},
+			},
+		},
+		{
+			name: "deployment containers with duplicates",
+			obj: map[string]any{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]any{"name": "web", "namespace": "demo"},
+				"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+					"initContainers": []any{map[string]any{
+						"name":    "init",
+						"envFrom": []any{map[string]any{"configMapRef": map[string]any{"name": "config"}}},
+					}},
+					"containers": []any{map[string]any{
+						"name":    "web",
+						"envFrom": []any{map[string]any{"secretRef": map[string]any{"name": "env"}}},
+						"env": []any{
+							map[string]any{"name": "A", "valueFrom": map[string]any{"configMapKeyRef": map[string]any{"name": "config", "key": "a"}}},
+							map[string]any{"name": "B", "valueFrom": map[string]any{"secretKeyRef": map[string]any{"name": "password", "key": "b"}}},
+							map[string]any{"name": "C", "value": "c"},
+						},
+					}},
+				}}},
+			},
+			want: []resourceRef{
+				{gvr: configMapGVR, kind: "ConfigMap", name: "config", namespace: "demo"},
+				{gvr: secretGVR, kind: "Secret", name: "env", namespace: "demo"},
+				{gvr: secretGVR, kind: "Secret", name: "password", namespace: "demo"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := specReferences(&unstructured.Unstructured{Object: tt.obj})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("specReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_selectorReferences(t *testing.T) {
+	object := func(apiVersion, kind, name string, spec map[string]any) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   map[string]any{"name": name, "namespace": "demo"},
+			"spec":       spec,
+		}}
+	}
+	deployment := object("apps/v1", "Deployment", "web", map[string]any{"template": map[string]any{
+		"metadata": map[string]any{"labels": map[string]any{"app": "web", "tier": "frontend"}},
+	}})
+	unlabeled := object("apps/v1", "Deployment", "web", map[string]any{"template": map[string]any{}})
+
+	lists := map[resourceTask][]unstructured.Unstructured{
+		{gvr: serviceGVR, namespace: "demo"}: {
+			object("v1", "Service", "web", map[string]any{"selector": map[string]any{"app": "web"}}),
+			object("v1", "Service", "db", map[string]any{"selector": map[string]any{"app": "db"}}),
+			object("v1", "Service", "external", map[string]any{"type": "ExternalName"}),
+		},
+		{gvr: pdbGVR, namespace: "demo"}: {
+			object("policy/v1", "PodDisruptionBudget", "web", map[string]any{"selector": map[string]any{
+				"matchExpressions": []any{map[string]any{"key": "tier", "operator": "In", "values": []any{"frontend"}}},
+			}}),
+			object("policy/v1", "PodDisruptionBudget", "all", map[string]any{"selector": map[string]any{}}),
+			object("policy/v1", "PodDisruptionBudget", "none", map[string]any{}),
+		},
+		{gvr: hpaGVR, namespace: "demo"}: {
+			object("autoscaling/v2", "HorizontalPodAutoscaler", "web", map[string]any{
+				"scaleTargetRef": map[string]any{"apiVersion": "apps/v1", "kind": "Deployment", "name": "web"},
+			}),
+			object("autoscaling/v2", "HorizontalPodAutoscaler", "other-group", map[string]any{
+				"scaleTargetRef": map[string]any{"apiVersion": "example.com/v1", "kind": "Deployment", "name": "web"},
+			}),
+			object("autoscaling/v2", "HorizontalPodAutoscaler", "db", map[string]any{
+				"scaleTargetRef": map[string]any{"apiVersion": "apps/v1", "kind": "Deployment", "name": "db"},
+			}),
+		},
+	}
+
+	tests := []struct {
+		name string
+		obj  unstructured.Unstructured
+		want []resourceRef
+	}{
+		{
+			name: "selected and scaled",
+			obj:  deployment,
+			want: []resourceRef{
+				{gvr: serviceGVR, kind: "Service", name: "web", namespace: "demo"},
+				{gvr: pdbGVR, kind: "PodDisruptionBudget", name: "web", namespace: "demo"},
+				{gvr: hpaGVR, kind: "HorizontalPodAutoscaler", name: "web", namespace: "demo"},
+			},
+		},
+		{
+			name: "without pod labels",
+			obj:  unlabeled,
+			want: []resourceRef{
+				{gvr: hpaGVR, kind: "HorizontalPodAutoscaler", name: "web", namespace: "demo"},
+			},
+		},
+		{
+			name: "object without pod spec",
+			obj:  object("v1", "ConfigMap", "web", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &applicationBackupManager{lists: lists}
+			got, err := opt.selectorReferences(&tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectorReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dumpReferences_bestEffort(t *testing.T) {
+	// the Services can't be listed and the Secrets can't be read
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/namespaces/demo/services", "/api/v1/namespaces/demo/secrets/tls":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"forbidden","reason":"Forbidden","code":403}`))
+		default:
+			_, _ = w.Write([]byte(`{"kind":"List","apiVersion":"v1","metadata":{},"items":[]}`))
+		}
+	}))
+	defer server.Close()
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]any{"name": "web", "namespace": "demo", "labels": map[string]any{"app": "web"}},
+		"spec": map[string]any{"volumes": []any{
+			map[string]any{"name": "tls", "secret": map[string]any{"secretName": "tls"}},
+		}},
+	}}
+
+	for _, strict := range []bool{false, true} {
+		opt := &applicationBackupManager{
+			di:     di,
+			strict: strict,
+			lists:  make(map[resourceTask][]unstructured.Unstructured),
+		}
+		err := opt.dumpReferences(make(map[types.UID][]resourceRef), pod, "")
+		if strict {
+			if err == nil {
+				t.Error("strict: expected an error for the Services that can't be listed")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resources []string
+		for _, w := range opt.treeWarnings {
+			resources = append(resources, w.Resource)
+		}
+		if want := []string{"services", "secrets"}; !reflect.DeepEqual(resources, want) {
+			t.Errorf("warnings = %v, want warnings for %v", opt.treeWarnings, want)
+		}
+	}
+}
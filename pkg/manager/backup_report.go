@@ -0,0 +1,210 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// BackupReportFileName is the name of the report that is written next to output.json and into the snapshot.
+const BackupReportFileName = "kubedump-report.json"
+
+// BackupReport records what the backup process dumped.
+type BackupReport struct {
+	// Duration is the time taken by the dump
+	Duration string `json:"duration"`
+	// TotalObjects is the number of dumped objects
+	TotalObjects int `json:"totalObjects"`
+	// TotalBytes is the size of the dumped objects
+	TotalBytes int64 `json:"totalBytes"`
+	// Resources shows the statistics of the processed resources sorted by the group, version and resource
+	Resources []ResourceBackupStats `json:"resources,omitempty"`
+	// Warnings lists the API groups and resources that could not be dumped. It is only set when the
+	// backup is not strict.
+	Warnings []DumpWarning `json:"warnings,omitempty"`
+	// Consistency is set when the resources are listed at the resource version of the start of the dump
+	Consistency *ConsistencyReport `json:"consistency,omitempty"`
+	// Changes lists the objects that have changed since the previous dump into the same directory
+	Changes *Changelog `json:"changes,omitempty"`
+}
+
+// ResourceBackupStats shows what has been dumped for a single resource.
+type ResourceBackupStats struct {
+	GroupVersion string `json:"groupVersion"`
+	Resource     string `json:"resource"`
+	// Dumped is the number of dumped objects
+	Dumped int `json:"dumped"`
+	// Skipped is the number of listed objects that have not been dumped
+	Skipped int `json:"skipped,omitempty"`
+	// Bytes is the size of the dumped objects
+	Bytes int64 `json:"bytes"`
+	// ListDuration is the time taken to list the objects. It is empty if the objects have been fetched individually.
+	ListDuration string `json:"listDuration,omitempty"`
+	// Error is set if the objects could not be listed
+	Error string `json:"error,omitempty"`
+}
+
+// DumpWarning describes an API group or a resource that has been skipped.
+type DumpWarning struct {
+	GroupVersion string `json:"groupVersion"`
+	// Resource is empty when the discovery of the whole group has failed.
+	Resource string `json:"resource,omitempty"`
+	// Namespace is set when the resource could not be listed in a single selected namespace.
+	Namespace string `json:"namespace,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+func (w DumpWarning) String() string {
+	switch {
+	case w.Resource == "":
+		return w.GroupVersion + ": " + w.Reason
+	case w.Namespace != "":
+		return w.GroupVersion + "/" + w.Resource + " in namespace " + w.Namespace + ": " + w.Reason
+	default:
+		return w.GroupVersion + "/" + w.Resource + ": " + w.Reason
+	}
+}
+
+// warningRecorder collects the warnings of the concurrent workers.
+type warningRecorder struct {
+	mu       sync.Mutex
+	warnings []DumpWarning
+}
+
+func (r *warningRecorder) record(w DumpWarning) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, w)
+}
+
+// list returns the recorded warnings sorted by the group and the resource.
+func (r *warningRecorder) list() []DumpWarning {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := append([]DumpWarning(nil), r.warnings...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].GroupVersion != out[j].GroupVersion {
+			return out[i].GroupVersion < out[j].GroupVersion
+		}
+		if out[i].Resource != out[j].Resource {
+			return out[i].Resource < out[j].Resource
+		}
+		return out[i].Namespace < out[j].Namespace
+	})
+	return out
+}
+
+// backupStats collects the statistics of the concurrent workers.
+type backupStats struct {
+	mu        sync.Mutex
+	start     time.Time
+	resources map[schema.GroupVersionResource]*resourceStats
+}
+
+type resourceStats struct {
+	dumped       int
+	skipped      int
+	bytes        int64
+	listDuration time.Duration
+	listed       bool
+	err          error
+}
+
+func newBackupStats() *backupStats {
+	return &backupStats{
+		start:     time.Now(),
+		resources: make(map[schema.GroupVersionResource]*resourceStats),
+	}
+}
+
+func (s *backupStats) get(gvr schema.GroupVersionResource) *resourceStats {
+	rs, ok := s.resources[gvr]
+	if !ok {
+		rs = &resourceStats{}
+		s.resources[gvr] = rs
+	}
+	return rs
+}
+
+func (s *backupStats) recordList(gvr schema.GroupVersionResource, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.get(gvr)
+	rs.listed = true
+	rs.listDuration += d
+	if err != nil {
+		rs.err = err
+	}
+}
+
+func (s *backupStats) recordDumped(gvr schema.GroupVersionResource, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs := s.get(gvr)
+	rs.dumped++
+	rs.bytes += int64(bytes)
+}
+
+func (s *backupStats) recordSkipped(gvr schema.GroupVersionResource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(gvr).skipped++
+}
+
+// report builds the report of the dump. Resources that have neither objects nor errors are omitted.
+func (s *backupStats) report(warnings []DumpWarning) *BackupReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &BackupReport{
+		Duration: time.Since(s.start).String(),
+		Warnings: warnings,
+	}
+	for gvr, rs := range s.resources {
+		if rs.dumped == 0 && rs.skipped == 0 && rs.err == nil {
+			continue
+		}
+		stats := ResourceBackupStats{
+			GroupVersion: gvr.GroupVersion().String(),
+			Resource:     gvr.Resource,
+			Dumped:       rs.dumped,
+			Skipped:      rs.skipped,
+			Bytes:        rs.bytes,
+		}
+		if rs.listed {
+			stats.ListDuration = rs.listDuration.String()
+		}
+		if rs.err != nil {
+			stats.Error = rs.err.Error()
+		}
+		r.TotalObjects += rs.dumped
+		r.TotalBytes += rs.bytes
+		r.Resources = append(r.Resources, stats)
+	}
+	sort.Slice(r.Resources, func(i, j int) bool {
+		if r.Resources[i].GroupVersion != r.Resources[j].GroupVersion {
+			return r.Resources[i].GroupVersion < r.Resources[j].GroupVersion
+		}
+		return r.Resources[i].Resource < r.Resources[j].Resource
+	})
+	return r
+}
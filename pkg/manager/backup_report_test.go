@@ -0,0 +1,98 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_backupStats_report(t *testing.T) {
+	var (
+		deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		podsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	)
+	s := newBackupStats()
+
+	// two pages of ConfigMaps, one of them is skipped
+	s.recordList(configMapGVR, 10*time.Millisecond, nil)
+	s.recordDumped(configMapGVR, 100)
+	s.recordList(configMapGVR, 5*time.Millisecond, nil)
+	s.recordDumped(configMapGVR, 50)
+	s.recordSkipped(configMapGVR)
+	// the Secrets can't be listed
+	s.recordList(secretGVR, time.Millisecond, errors.New("forbidden"))
+	// the Deployments are fetched individually
+	s.recordDumped(deploymentsGVR, 30)
+	// the Pods have no objects
+	s.recordList(podsGVR, time.Millisecond, nil)
+	// the Services are dumped by concurrent workers
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Go(func() {
+			s.recordDumped(serviceGVR, 1)
+		})
+	}
+	wg.Wait()
+
+	warnings := []DumpWarning{{GroupVersion: "v1", Resource: "secrets", Reason: "forbidden"}}
+	got := s.report(warnings)
+	want := []ResourceBackupStats{
+		{GroupVersion: "apps/v1", Resource: "deployments", Dumped: 1, Bytes: 30},
+		{GroupVersion: "v1", Resource: "configmaps", Dumped: 2, Skipped: 1, Bytes: 150, ListDuration: "15ms"},
+		{GroupVersion: "v1", Resource: "secrets", ListDuration: "1ms", Error: "forbidden"},
+		{GroupVersion: "v1", Resource: "services", Dumped: 100, Bytes: 100},
+	}
+	if !reflect.DeepEqual(got.Resources, want) {
+		t.Errorf("resources = %+v, want %+v", got.Resources, want)
+	}
+	if got.TotalObjects != 103 || got.TotalBytes != 280 {
+		t.Errorf("totals = %d objects, %d bytes, want 103 objects, 280 bytes", got.TotalObjects, got.TotalBytes)
+	}
+	if !reflect.DeepEqual(got.Warnings, warnings) {
+		t.Errorf("warnings = %v, want %v", got.Warnings, warnings)
+	}
+	if _, err := time.ParseDuration(got.Duration); err != nil {
+		t.Errorf("invalid duration %q: %v", got.Duration, err)
+	}
+}
+
+func Test_warningRecorder_list(t *testing.T) {
+	var r warningRecorder
+	for _, w := range []DumpWarning{
+		{GroupVersion: "v1", Resource: "secrets", Namespace: "b"},
+		{GroupVersion: "metrics.k8s.io/v1beta1"},
+		{GroupVersion: "v1", Resource: "secrets", Namespace: "a"},
+		{GroupVersion: "v1", Resource: "configmaps"},
+	} {
+		r.record(w)
+	}
+	want := []DumpWarning{
+		{GroupVersion: "metrics.k8s.io/v1beta1"},
+		{GroupVersion: "v1", Resource: "configmaps"},
+		{GroupVersion: "v1", Resource: "secrets", Namespace: "a"},
+		{GroupVersion: "v1", Resource: "secrets", Namespace: "b"},
+	}
+	if got := r.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list() = %v, want %v", got, want)
+	}
+}
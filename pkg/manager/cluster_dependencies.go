@@ -0,0 +1,254 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+var (
+	clusterRoleGVR      = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+	persistentVolumeGVR = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}
+	storageClassGVR     = schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+	priorityClassGVR    = schema.GroupVersionResource{Group: "scheduling.k8s.io", Version: "v1", Resource: "priorityclasses"}
+	ingressClassGVR     = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"}
+)
+
+// clusterObjectRef refers to a cluster scoped object.
+type clusterObjectRef struct {
+	gvr  schema.GroupVersionResource
+	kind string
+	name string
+}
+
+// podSpecFields are the paths of the pod specs of the built-in workloads.
+var podSpecFields = map[schema.GroupKind][]string{
+	{Kind: "Pod"}:                        {"spec"},
+	{Kind: "ReplicationController"}:      {"spec", "template", "spec"},
+	{Group: "apps", Kind: "Deployment"}:  {"spec", "template", "spec"},
+	{Group: "apps", Kind: "StatefulSet"}: {"spec", "template", "spec"},
+	{Group: "apps", Kind: "DaemonSet"}:   {"spec", "template", "spec"},
+	{Group: "apps", Kind: "ReplicaSet"}:  {"spec", "template", "spec"},
+	{Group: "batch", Kind: "Job"}:        {"spec", "template", "spec"},
+	{Group: "batch", Kind: "CronJob"}:    {"spec", "jobTemplate", "spec", "template", "spec"},
+	{Kind: "PodTemplate"}:                {"template", "spec"},
+}
+
+// clusterReferences returns the cluster scoped objects that the object depends on.
+func clusterReferences(obj *unstructured.Unstructured, gvr schema.GroupVersionResource) []clusterObjectRef {
+	var refs []clusterObjectRef
+	add := func(gvr schema.GroupVersionResource, kind, name string) {
+		if name != "" {
+			refs = append(refs, clusterObjectRef{gvr: gvr, kind: kind, name: name})
+		}
+	}
+
+	if ns := obj.GetNamespace(); ns != "" {
+		add(namespaceGVR, namespaceGroupKind.Kind, ns)
+	}
+	// the custom resources are served by the CRD named <resource>.<group>
+	if isCustomGroup(gvr.Group) {
+		add(crdGVR, crdGroupKind.Kind, gvr.Resource+"."+gvr.Group)
+	}
+
+	gk := obj.GroupVersionKind().GroupKind()
+	switch gk {
+	case schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}:
+		if kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); kind == "ClusterRole" {
+			name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+			add(clusterRoleGVR, "ClusterRole", name)
+		}
+	case schema.GroupKind{Kind: "PersistentVolumeClaim"}:
+		name, _, _ := unstructured.NestedString(obj.Object, "spec", "volumeName")
+		add(persistentVolumeGVR, "PersistentVolume", name)
+		name, _, _ = unstructured.NestedString(obj.Object, "spec", "storageClassName")
+		add(storageClassGVR, "StorageClass", name)
+	case schema.GroupKind{Kind: "PersistentVolume"}:
+		name, _, _ := unstructured.NestedString(obj.Object, "spec", "storageClassName")
+		add(storageClassGVR, "StorageClass", name)
+	case schema.GroupKind{Group: "networking.k8s.io", Kind: "Ingress"}:
+		name, _, _ := unstructured.NestedString(obj.Object, "spec", "ingressClassName")
+		add(ingressClassGVR, "IngressClass", name)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		templates, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumeClaimTemplates")
+		for _, t := range templates {
+			if m, ok := t.(map[string]any); ok {
+				name, _, _ := unstructured.NestedString(m, "spec", "storageClassName")
+				add(storageClassGVR, "StorageClass", name)
+			}
+		}
+	}
+	if fields := podSpecFields[gk]; fields != nil {
+		name, _, _ := unstructured.NestedString(obj.Object, append(fields, "priorityClassName")...)
+		add(priorityClassGVR, "PriorityClass", name)
+	}
+	return refs
+}
+
+// isCustomGroup reports whether the group may contain custom resources. The group of a CRD must have a
+// domain, but the built-in groups with a domain can't be told apart from the custom ones by their names
+// (i.e. snapshot.storage.k8s.io is served by a CRD while storage.k8s.io is built-in). So the CRD of
+// every such group is a candidate, which is only fetched if the cluster serves it.
+func isCustomGroup(group string) bool {
+	return strings.Contains(group, ".")
+}
+
+// clusterDependencyCollector records the cluster scoped objects referenced by the processed items.
+// It is safe for concurrent use.
+type clusterDependencyCollector struct {
+	itemProcessor
+	mu   sync.Mutex
+	refs map[clusterObjectRef]bool
+	// crds are the CRDs served by the cluster by their names. They are listed once, when the first CRD
+	// is referenced.
+	crds map[string]*unstructured.Unstructured
+}
+
+func newClusterDependencyCollector(p itemProcessor) *clusterDependencyCollector {
+	return &clusterDependencyCollector{
+		itemProcessor: p,
+		refs:          make(map[clusterObjectRef]bool),
+	}
+}
+
+func (c *clusterDependencyCollector) Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+	if err := c.itemProcessor.Process(items, gvr); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range items {
+		// the cluster scoped objects that have already been processed don't need to be fetched again
+		if items[i].GetNamespace() == "" {
+			c.refs[clusterObjectRef{gvr: gvr, kind: items[i].GetKind(), name: items[i].GetName()}] = true
+		}
+		for _, ref := range clusterReferences(&items[i], gvr) {
+			if _, ok := c.refs[ref]; !ok {
+				c.refs[ref] = false
+			}
+		}
+	}
+	return nil
+}
+
+// pending returns the references that haven't been resolved yet in a stable order and marks them as resolved.
+func (c *clusterDependencyCollector) pending() []clusterObjectRef {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var refs []clusterObjectRef
+	for ref, resolved := range c.refs {
+		if !resolved {
+			refs = append(refs, ref)
+			c.refs[ref] = true
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].gvr != refs[j].gvr {
+			return refs[i].gvr.String() < refs[j].gvr.String()
+		}
+		return refs[i].name < refs[j].name
+	})
+	return refs
+}
+
+// processClusterDependencies fetches the referenced cluster scoped objects and passes them to the collector,
+// which in turn records the objects they depend on (i.e. the StorageClass of a PersistentVolume).
+func (opt *resourceProcessor) processClusterDependencies(c *clusterDependencyCollector) error {
+	for refs := c.pending(); len(refs) > 0; refs = c.pending() {
+		for _, ref := range refs {
+			if opt.shouldIgnoreResource(schema.GroupKind{Group: ref.gvr.Group, Kind: ref.kind}) ||
+				!opt.resourceFilter.allows(ref.gvr, ref.kind) {
+				continue
+			}
+
+			if ref.gvr == crdGVR {
+				crds, err := opt.listCRDs(c)
+				if err != nil {
+					return err
+				}
+				// a group with a domain is built-in if the cluster has no CRD for it
+				if crd, ok := crds[ref.name]; ok {
+					opt.consistency.check([]unstructured.Unstructured{*crd}, ref.gvr)
+					if err := c.Process([]unstructured.Unstructured{*crd}, ref.gvr); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			obj, err := opt.di.Resource(ref.gvr).Get(context.TODO(), ref.name, metav1.GetOptions{})
+			if err != nil {
+				if kerr.IsNotFound(err) {
+					klog.V(5).Infof("Skipping %s %s as it does not exist", ref.kind, ref.name)
+					continue
+				}
+				if opt.strict {
+					return err
+				}
+				klog.Warningf("Skipping %s %s as it can't be fetched: %v", ref.kind, ref.name, err)
+				opt.warnings.record(DumpWarning{GroupVersion: ref.gvr.GroupVersion().String(), Resource: ref.gvr.Resource, Reason: err.Error()})
+				continue
+			}
+			opt.consistency.check([]unstructured.Unstructured{*obj}, ref.gvr)
+			if err := c.Process([]unstructured.Unstructured{*obj}, ref.gvr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listCRDs returns the CRDs served by the cluster by their names. If they can't be listed when not strict,
+// a single warning is recorded and no CRD is returned.
+func (opt *resourceProcessor) listCRDs(c *clusterDependencyCollector) (map[string]*unstructured.Unstructured, error) {
+	if c.crds != nil {
+		return c.crds, nil
+	}
+	crds := make(map[string]*unstructured.Unstructured)
+	var next string
+	for {
+		list, err := opt.di.Resource(crdGVR).List(context.TODO(), metav1.ListOptions{Limit: 250, Continue: next})
+		if err != nil {
+			if opt.strict {
+				return nil, err
+			}
+			klog.Warningf("Skipping the CRDs of the custom resources as they can't be listed: %v", err)
+			opt.warnings.record(DumpWarning{GroupVersion: crdGVR.GroupVersion().String(), Resource: crdGVR.Resource, Reason: err.Error()})
+			crds = make(map[string]*unstructured.Unstructured)
+			break
+		}
+		for i := range list.Items {
+			crds[list.Items[i].GetName()] = &list.Items[i]
+		}
+		if next = list.GetContinue(); next == "" {
+			break
+		}
+	}
+	c.crds = crds
+	return crds, nil
+}
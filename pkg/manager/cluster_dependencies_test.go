@@ -0,0 +1,241 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func Test_clusterReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		gvr  schema.GroupVersionResource
+		obj  map[string]any
+		want []clusterObjectRef
+	}{
+		{
+			name: "role binding to cluster role",
+			gvr:  schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"},
+			obj: map[string]any{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   map[string]any{"name": "view", "namespace": "demo"},
+				"roleRef":    map[string]any{"kind": "ClusterRole", "name": "view"},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: crdGVR, kind: "CustomResourceDefinition", name: "rolebindings.rbac.authorization.k8s.io"},
+				{gvr: clusterRoleGVR, kind: "ClusterRole", name: "view"},
+			},
+		},
+		{
+			name: "bound claim",
+			gvr:  schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"},
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "PersistentVolumeClaim",
+				"metadata":   map[string]any{"name": "data", "namespace": "demo"},
+				"spec":       map[string]any{"volumeName": "pv-1", "storageClassName": "standard"},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: persistentVolumeGVR, kind: "PersistentVolume", name: "pv-1"},
+				{gvr: storageClassGVR, kind: "StorageClass", name: "standard"},
+			},
+		},
+		{
+			name: "cron job with priority class",
+			gvr:  schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"},
+			obj: map[string]any{
+				"apiVersion": "batch/v1",
+				"kind":       "CronJob",
+				"metadata":   map[string]any{"name": "report", "namespace": "demo"},
+				"spec": map[string]any{"jobTemplate": map[string]any{"spec": map[string]any{"template": map[string]any{
+					"spec": map[string]any{"priorityClassName": "low"},
+				}}}},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: priorityClassGVR, kind: "PriorityClass", name: "low"},
+			},
+		},
+		{
+			name: "custom resource",
+			gvr:  schema.GroupVersionResource{Group: "kubedb.com", Version: "v1", Resource: "postgreses"},
+			obj: map[string]any{
+				"apiVersion": "kubedb.com/v1",
+				"kind":       "Postgres",
+				"metadata":   map[string]any{"name": "db", "namespace": "demo"},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: crdGVR, kind: "CustomResourceDefinition", name: "postgreses.kubedb.com"},
+			},
+		},
+		{
+			name: "custom resource of a k8s.io group",
+			gvr:  schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"},
+			obj: map[string]any{
+				"apiVersion": "snapshot.storage.k8s.io/v1",
+				"kind":       "VolumeSnapshot",
+				"metadata":   map[string]any{"name": "data", "namespace": "demo"},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: crdGVR, kind: "CustomResourceDefinition", name: "volumesnapshots.snapshot.storage.k8s.io"},
+			},
+		},
+		{
+			name: "custom resource of a x-k8s.io group",
+			gvr:  schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machines"},
+			obj: map[string]any{
+				"apiVersion": "cluster.x-k8s.io/v1beta1",
+				"kind":       "Machine",
+				"metadata":   map[string]any{"name": "node-1", "namespace": "demo"},
+			},
+			want: []clusterObjectRef{
+				{gvr: namespaceGVR, kind: "Namespace", name: "demo"},
+				{gvr: crdGVR, kind: "CustomResourceDefinition", name: "machines.cluster.x-k8s.io"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clusterReferences(&unstructured.Unstructured{Object: tt.obj}, tt.gvr)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clusterReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processClusterDependencies(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		forbid   bool
+	)
+	// the cluster serves the CRD of the VolumeSnapshots, discovery.k8s.io is built-in
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/apis/apiextensions.k8s.io/v1/customresourcedefinitions" && forbid:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"forbidden","reason":"Forbidden","code":403}`))
+		case r.URL.Path == "/apis/apiextensions.k8s.io/v1/customresourcedefinitions":
+			_, _ = w.Write([]byte(`{"kind":"CustomResourceDefinitionList","apiVersion":"apiextensions.k8s.io/v1","metadata":{},"items":[` +
+				`{"apiVersion":"apiextensions.k8s.io/v1","kind":"CustomResourceDefinition","metadata":{"name":"snapshots.snapshot.storage.k8s.io"}}]}`))
+		case r.URL.Path == "/api/v1/namespaces/demo":
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"demo"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		}
+	}))
+	defer server.Close()
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object := func(apiVersion, kind string) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"metadata":   map[string]any{"name": "a", "namespace": "demo"},
+		}}
+	}
+	process := func(strict bool) ([]string, []DumpWarning, error) {
+		requests = nil
+		recorder := &kindsRecorder{}
+		c := newClusterDependencyCollector(recorder)
+		for gvr, obj := range map[schema.GroupVersionResource]unstructured.Unstructured{
+			{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "snapshots"}:       object("snapshot.storage.k8s.io/v1", "VolumeSnapshot"),
+			{Group: "discovery.k8s.io", Version: "v1", Resource: "endpointslices"}:         object("discovery.k8s.io/v1", "EndpointSlice"),
+			{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}:              object("coordination.k8s.io/v1", "Lease"),
+			{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "snapshotclasses"}: object("snapshot.storage.k8s.io/v1", "VolumeSnapshotClass"),
+		} {
+			if err := c.Process([]unstructured.Unstructured{obj}, gvr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		recorder.kinds = nil
+		opt := &resourceProcessor{di: di, strict: strict}
+		err := opt.processClusterDependencies(c)
+		sort.Strings(recorder.kinds)
+		return recorder.kinds, opt.warnings.list(), err
+	}
+
+	kinds, warnings, err := process(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"CustomResourceDefinition", "Namespace"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("processed %v, want %v", kinds, want)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none", warnings)
+	}
+	want := []string{"/api/v1/namespaces/demo", "/apis/apiextensions.k8s.io/v1/customresourcedefinitions"}
+	if sort.Strings(requests); !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want a single list of the CRDs", requests)
+	}
+
+	// the CRDs can't be read
+	forbid = true
+	kinds, warnings, err = process(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Namespace"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("processed %v, want %v", kinds, want)
+	}
+	if len(warnings) != 1 || warnings[0].Resource != "customresourcedefinitions" {
+		t.Errorf("warnings = %v, want a single warning for the CRDs", warnings)
+	}
+	if _, _, err := process(true); err == nil {
+		t.Error("strict: expected an error for the CRDs that can't be listed")
+	}
+}
+
+// kindsRecorder records the kinds of the processed items.
+type kindsRecorder struct {
+	mu    sync.Mutex
+	kinds []string
+}
+
+func (r *kindsRecorder) Process(items []unstructured.Unstructured, _ schema.GroupVersionResource) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range items {
+		r.kinds = append(r.kinds, item.GetKind())
+	}
+	return nil
+}
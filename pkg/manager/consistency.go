@@ -0,0 +1,197 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// ConsistencyReport shows how consistent the dump is with the state of the cluster at its start.
+type ConsistencyReport struct {
+	// Mode is the resourceVersionMatch used to list the resources
+	Mode metav1.ResourceVersionMatch `json:"mode"`
+	// ResourceVersion is the resource version of the cluster at the start of the dump
+	ResourceVersion string `json:"resourceVersion"`
+	// Fallbacks are the resources that have been listed at their latest version as the server could not
+	// list them at the resource version of the dump
+	Fallbacks []DumpWarning `json:"fallbacks,omitempty"`
+	// ChangedObjects are the dumped objects that have been modified after the start of the dump
+	ChangedObjects []ChangedObject `json:"changedObjects,omitempty"`
+}
+
+// ChangedObject is an object that has been modified during the dump.
+type ChangedObject struct {
+	GroupVersion    string `json:"groupVersion"`
+	Resource        string `json:"resource"`
+	Namespace       string `json:"namespace,omitempty"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// consistency lists the resources at the resource version of the cluster at the start of the dump.
+// A nil consistency lists the resources at their latest version. It is safe for concurrent use.
+type consistency struct {
+	mu              sync.Mutex
+	match           metav1.ResourceVersionMatch
+	resourceVersion string
+	// revision is the numeric form of the resource version. It is zero if the resource version is not numeric.
+	revision  uint64
+	fallbacks []DumpWarning
+	changed   []ChangedObject
+}
+
+// newConsistency returns the consistency of the given mode. It returns nil if the mode is empty.
+func newConsistency(mode string) (*consistency, error) {
+	switch metav1.ResourceVersionMatch(mode) {
+	case "":
+		return nil, nil
+	case metav1.ResourceVersionMatchNotOlderThan, metav1.ResourceVersionMatchExact:
+		return &consistency{match: metav1.ResourceVersionMatch(mode)}, nil
+	default:
+		return nil, fmt.Errorf("invalid consistency %q, must be one of %s or %s", mode,
+			metav1.ResourceVersionMatchNotOlderThan, metav1.ResourceVersionMatchExact)
+	}
+}
+
+// start records the current resource version of the cluster, unless it has already been recorded.
+// The resource version of a list is the latest resource version of the storage, whatever the listed
+// resource is. The ConfigMaps of the namespace are listed if a namespace is given, as the dump of a
+// namespace may not be allowed to list the cluster scoped resources. Otherwise, or if the ConfigMaps
+// can't be listed, the Namespaces are listed.
+func (c *consistency) start(di dynamic.Interface, namespace string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceVersion != "" {
+		return nil
+	}
+
+	var (
+		list *unstructured.UnstructuredList
+		err  error
+	)
+	if namespace != "" {
+		list, err = di.Resource(configMapGVR).Namespace(namespace).List(context.TODO(), metav1.ListOptions{Limit: 1})
+		if err != nil {
+			klog.V(3).Infof("Failed to list the ConfigMaps of namespace %s for the resource version of the dump: %v", namespace, err)
+		}
+	}
+	if list == nil {
+		list, err = di.Resource(namespaceGVR).List(context.TODO(), metav1.ListOptions{Limit: 1})
+		if err != nil {
+			return err
+		}
+	}
+	c.resourceVersion = list.GetResourceVersion()
+	c.revision, _ = strconv.ParseUint(c.resourceVersion, 10, 64)
+	klog.Infof("Dumping resources at resource version %s (%s)", c.resourceVersion, c.match)
+	return nil
+}
+
+// listOptions sets the resource version of the first page of a list. The following pages are listed
+// at the resource version encoded in the continue token.
+func (c *consistency) listOptions(opts *metav1.ListOptions) {
+	if c == nil || opts.Continue != "" {
+		return
+	}
+	opts.ResourceVersion = c.resourceVersion
+	opts.ResourceVersionMatch = c.match
+}
+
+// shouldFallback reports whether the list error means that the resource can't be listed at the
+// resource version of the dump, i.e. it has been compacted or the server does not support it.
+func (c *consistency) shouldFallback(err error) bool {
+	return c != nil && (kerr.IsResourceExpired(err) || kerr.IsGone(err) || kerr.IsBadRequest(err) || kerr.IsInvalid(err))
+}
+
+func (c *consistency) recordFallback(gvr schema.GroupVersionResource, namespace string, err error) {
+	klog.Warningf("Listing resource %s at its latest version as it can't be listed at resource version %s: %v", gvr, c.resourceVersion, err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallbacks = append(c.fallbacks, DumpWarning{
+		GroupVersion: gvr.GroupVersion().String(),
+		Resource:     gvr.Resource,
+		Namespace:    namespace,
+		Reason:       err.Error(),
+	})
+}
+
+// check records the objects that have been modified after the start of the dump.
+func (c *consistency) check(items []unstructured.Unstructured, gvr schema.GroupVersionResource) {
+	if c == nil || c.revision == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range items {
+		rv := items[i].GetResourceVersion()
+		if revision, err := strconv.ParseUint(rv, 10, 64); err == nil && revision > c.revision {
+			c.changed = append(c.changed, ChangedObject{
+				GroupVersion:    gvr.GroupVersion().String(),
+				Resource:        gvr.Resource,
+				Namespace:       items[i].GetNamespace(),
+				Name:            items[i].GetName(),
+				ResourceVersion: rv,
+			})
+		}
+	}
+}
+
+func (c *consistency) report() *ConsistencyReport {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &ConsistencyReport{
+		Mode:            c.match,
+		ResourceVersion: c.resourceVersion,
+		Fallbacks:       append([]DumpWarning(nil), c.fallbacks...),
+		ChangedObjects:  append([]ChangedObject(nil), c.changed...),
+	}
+	sort.Slice(r.Fallbacks, func(i, j int) bool {
+		return r.Fallbacks[i].String() < r.Fallbacks[j].String()
+	})
+	sort.Slice(r.ChangedObjects, func(i, j int) bool {
+		a, b := r.ChangedObjects[i], r.ChangedObjects[j]
+		if a.GroupVersion != b.GroupVersion {
+			return a.GroupVersion < b.GroupVersion
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return r
+}
@@ -0,0 +1,99 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func Test_consistency(t *testing.T) {
+	if c, err := newConsistency(""); err != nil || c != nil {
+		t.Fatalf("newConsistency(\"\") = %v, %v, want nil", c, err)
+	}
+	if _, err := newConsistency("Latest"); err == nil {
+		t.Fatal("expected an error for an invalid consistency")
+	}
+
+	c, err := newConsistency("Exact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.resourceVersion, c.revision = "100", 100
+
+	opts := metav1.ListOptions{}
+	c.listOptions(&opts)
+	if opts.ResourceVersion != "100" || opts.ResourceVersionMatch != metav1.ResourceVersionMatchExact {
+		t.Errorf("first page is listed at %q (%s)", opts.ResourceVersion, opts.ResourceVersionMatch)
+	}
+	opts = metav1.ListOptions{Continue: "token"}
+	c.listOptions(&opts)
+	if opts.ResourceVersion != "" || opts.ResourceVersionMatch != "" {
+		t.Errorf("next page is listed at %q (%s), want the version of the continue token", opts.ResourceVersion, opts.ResourceVersionMatch)
+	}
+
+	var items []unstructured.Unstructured
+	for _, rv := range []string{"99", "100", "101", "abc"} {
+		obj := unstructured.Unstructured{}
+		obj.SetName("cm-" + rv)
+		obj.SetResourceVersion(rv)
+		items = append(items, obj)
+	}
+	c.check(items, schema.GroupVersionResource{Version: "v1", Resource: "configmaps"})
+	changed := c.report().ChangedObjects
+	if len(changed) != 1 || changed[0].Name != "cm-101" {
+		t.Errorf("changed objects = %v, want only cm-101", changed)
+	}
+}
+
+func Test_consistencyStart(t *testing.T) {
+	// the server only allows to list the resources of namespace demo
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/namespaces/demo/configmaps" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"kind":"ConfigMapList","apiVersion":"v1","metadata":{"resourceVersion":"42"},"items":[]}`))
+	}))
+	defer server.Close()
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := newConsistency("Exact")
+	if err := c.start(di, "demo"); err != nil {
+		t.Fatal(err)
+	}
+	if c.resourceVersion != "42" || c.revision != 42 {
+		t.Errorf("resource version = %s, want 42", c.resourceVersion)
+	}
+
+	c, _ = newConsistency("Exact")
+	if err := c.start(di, "other"); err == nil {
+		t.Error("expected an error when neither the ConfigMaps nor the Namespaces can be listed")
+	}
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package manager
 
 import (
+	"crypto/ed25519"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"stash.appscode.dev/apimachinery/apis"
@@ -39,40 +41,125 @@ type genericResourceBackupManager struct {
 	selector         string
 	useRootDataDir   bool
 	ignoreGroupKinds []string
+	includeResources []string
+	excludeResources []string
+	// includeNamespaces, excludeNamespaces and namespaceSelector filter the namespaces of a cluster backup
+	includeNamespaces []string
+	excludeNamespaces []string
+	namespaceSelector string
+	targetNamespaces  []string
+	// includeClusterDependencies adds the cluster scoped objects that the dumped objects of a namespace depend on
+	includeClusterDependencies bool
+	concurrency                int
+	strict                     bool
+	consistency                string
+	signingKey                 ed25519.PrivateKey
+	encryptionKey              []byte
+	secretPolicy               sanitizers.SecretPolicy
 }
 
 func newGenericResourceBackupManager(opt BackupOptions) BackupManager {
 	mgr := genericResourceBackupManager{
-		config:           opt.Config,
-		storage:          opt.Storage,
-		sanitize:         opt.Sanitize,
-		dataDir:          opt.DataDir,
-		selector:         opt.Selector,
-		ignoreGroupKinds: opt.IgnoreGroupKinds,
-	}
-	if opt.Target.Kind == apis.KindNamespace {
+		config:                     opt.Config,
+		storage:                    opt.Storage,
+		sanitize:                   opt.Sanitize,
+		dataDir:                    opt.DataDir,
+		selector:                   opt.Selector,
+		ignoreGroupKinds:           opt.IgnoreGroupKinds,
+		includeResources:           opt.IncludeResources,
+		excludeResources:           opt.ExcludeResources,
+		includeNamespaces:          opt.IncludeNamespaces,
+		excludeNamespaces:          opt.ExcludeNamespaces,
+		namespaceSelector:          opt.NamespaceSelector,
+		concurrency:                opt.Concurrency,
+		strict:                     opt.Strict,
+		includeClusterDependencies: opt.IncludeClusterDependencies,
+		consistency:                opt.Consistency,
+		signingKey:                 opt.SigningKey,
+		encryptionKey:              opt.EncryptionKey,
+		secretPolicy:               opt.SecretPolicy,
+	}
+	switch {
+	case len(opt.Namespaces) > 0:
+		// the resources of multiple namespaces are stored in the same layout as a cluster backup
+		mgr.targetNamespaces = opt.Namespaces
+		if opt.Target.Kind == apis.KindNamespace && !slices.Contains(opt.Namespaces, opt.Target.Name) {
+			mgr.targetNamespaces = append([]string{opt.Target.Name}, opt.Namespaces...)
+		}
+	case opt.Target.Kind == apis.KindNamespace:
 		mgr.namespace = opt.Target.Name
 		mgr.useRootDataDir = true
 	}
 	return mgr
 }
 
-func (opt genericResourceBackupManager) Dump() error {
+func (opt genericResourceBackupManager) Dump() (*BackupReport, error) {
+	consistency, err := newConsistency(opt.consistency)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := newSecretEncryptor(opt.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := opt.secretPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	stats := newBackupStats()
+	index := newDumpIndex(opt.dataDir)
 	processor := itemDumper{
 		sanitize:       opt.sanitize,
 		dataDir:        opt.dataDir,
 		storage:        opt.storage,
 		useRootDataDir: opt.useRootDataDir,
+		stats:          stats,
+		index:          index,
+		encryptor:      encryptor,
+		secretPolicy:   opt.secretPolicy,
+	}
+
+	var (
+		itemProcessor itemProcessor = processor
+		collector     *clusterDependencyCollector
+	)
+	if opt.includeClusterDependencies && (opt.namespace != "" || len(opt.targetNamespaces) > 0) {
+		collector = newClusterDependencyCollector(processor)
+		itemProcessor = collector
 	}
 
 	rp := resourceProcessor{
-		config:           opt.config,
-		namespace:        opt.namespace,
-		selector:         opt.selector,
-		itemProcessor:    processor,
-		ignoreGroupKinds: opt.ignoreGroupKinds,
+		config:            opt.config,
+		namespace:         opt.namespace,
+		selector:          opt.selector,
+		itemProcessor:     itemProcessor,
+		ignoreGroupKinds:  opt.ignoreGroupKinds,
+		includeResources:  opt.includeResources,
+		excludeResources:  opt.excludeResources,
+		includeNamespaces: opt.includeNamespaces,
+		excludeNamespaces: opt.excludeNamespaces,
+		namespaceSelector: opt.namespaceSelector,
+		targetNamespaces:  opt.targetNamespaces,
+		concurrency:       opt.concurrency,
+		strict:            opt.strict,
+		stats:             stats,
+		consistency:       consistency,
+	}
+	warnings, err := rp.processAPIResources()
+	if err != nil {
+		return nil, err
+	}
+	if collector != nil {
+		if err := rp.processClusterDependencies(collector); err != nil {
+			return nil, err
+		}
+		warnings = rp.warnings.list()
 	}
-	return rp.processAPIResources()
+	if err := index.write(opt.storage, opt.signingKey); err != nil {
+		return nil, err
+	}
+	report := stats.report(warnings)
+	report.Consistency = consistency.report()
+	return report, nil
 }
 
 type itemDumper struct {
@@ -80,26 +167,35 @@ type itemDumper struct {
 	dataDir        string
 	storage        Writer
 	useRootDataDir bool
+	stats          *backupStats
+	index          *dumpIndex
+	encryptor      *secretEncryptor
+	secretPolicy   sanitizers.SecretPolicy
 }
 
-func (opt itemDumper) Process(items []unstructured.Unstructured, _ schema.GroupVersionResource) error {
-	var err error
+func (opt itemDumper) Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error {
 	for _, r := range items {
-		data := r.Object
-		if opt.sanitize {
-			s := sanitizers.NewSanitizer(r.GetKind())
-			data, err = s.Sanitize(data)
-			if err != nil {
-				return err
-			}
-			delete(data, "status")
+		entry := newIndexEntry(&r)
+		data, err := sanitizeObject(r.Object, r.GetKind(), opt.sanitize, opt.secretPolicy)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			opt.stats.recordSkipped(gvr)
+			continue
 		}
 
 		fileName := opt.getFileName(r)
-		err = storeItem(fileName, data, opt.storage)
+		data, err = opt.encryptor.encryptItem(data, fileName, opt.storage)
+		if err != nil {
+			return err
+		}
+		out, err := storeItem(fileName, data, opt.storage)
 		if err != nil {
 			return err
 		}
+		opt.stats.recordDumped(gvr, len(out))
+		opt.index.record(entry, fileName, out)
 	}
 	return nil
 }
@@ -118,16 +214,31 @@ func (opt *itemDumper) getFileName(r unstructured.Unstructured) string {
 	return filepath.Join(prefix, r.GetKind(), r.GetName()) + ".yaml"
 }
 
-func storeItem(fileName string, in map[string]any, storage Writer) error {
+// sanitizeObject removes the fields populated by the API server from the object, if enabled, and applies
+// the Secret policy either way. It returns nil if the object must not be dumped. The object may be modified.
+func sanitizeObject(in map[string]any, kind string, sanitize bool, policy sanitizers.SecretPolicy) (map[string]any, error) {
+	if !sanitize {
+		return policy.Apply(in), nil
+	}
+	out, err := sanitizers.NewSanitizer(kind, policy).Sanitize(in)
+	if err != nil || out == nil {
+		return nil, err
+	}
+	delete(out, "status")
+	return out, nil
+}
+
+// storeItem writes the item and returns the written content.
+func storeItem(fileName string, in map[string]any, storage Writer) ([]byte, error) {
 	data, err := yaml.Marshal(in)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = storage.Write(fileName, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return data, nil
 }
 
 func isSubResource(name string) bool {
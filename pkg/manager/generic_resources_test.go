@@ -0,0 +1,74 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"strings"
+	"testing"
+
+	"stash.appscode.dev/kubedump/pkg/sanitizers"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_itemDumper_secretPolicy(t *testing.T) {
+	secretGVR := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	newSecret := func(name, secretType string) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]any{
+			"type": secretType,
+			"data": map[string]any{"password": "czNjcjN0"},
+		}}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Secret")
+		obj.SetNamespace("demo")
+		obj.SetName(name)
+		return obj
+	}
+
+	// the policy applies even if the objects are not sanitized
+	for _, sanitize := range []bool{true, false} {
+		w := &memoryWriter{files: map[string][]byte{}}
+		dumper := itemDumper{
+			storage:  w,
+			sanitize: sanitize,
+			stats:    newBackupStats(),
+			secretPolicy: sanitizers.SecretPolicy{
+				Mode:      sanitizers.SecretModeRedact,
+				SkipTypes: sanitizers.DefaultSkippedSecretTypes,
+			},
+		}
+		items := []unstructured.Unstructured{
+			newSecret("db", "Opaque"),
+			newSecret("token", "kubernetes.io/service-account-token"),
+		}
+		if err := dumper.Process(items, secretGVR); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.files) != 1 {
+			t.Errorf("sanitize=%v: dumped %d files, want the service account token to be skipped", sanitize, len(w.files))
+		}
+		for name, data := range w.files {
+			if strings.Contains(string(data), "czNjcjN0") {
+				t.Errorf("sanitize=%v: %s has not been redacted:\n%s", sanitize, name, data)
+			}
+		}
+		if rs := dumper.stats.get(secretGVR); rs.dumped != 1 || rs.skipped != 1 {
+			t.Errorf("sanitize=%v: dumped %d and skipped %d, want 1 and 1", sanitize, rs.dumped, rs.skipped)
+		}
+	}
+}
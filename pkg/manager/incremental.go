@@ -0,0 +1,270 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// ManifestFileName is the name of the manifest that records the content hashes of the dumped files.
+// It is kept in the root of the dump so that the next dump into the same directory knows which files
+// it has to rewrite and which ones it can delete.
+const ManifestFileName = "kubedump-manifest.json"
+
+// manifestJournalFileName is the name of the journal of the files written by a dump that has not finished yet.
+// Every path is appended to it before the file is written, so that the next dump can repair a failed one.
+const manifestJournalFileName = "kubedump-manifest.journal"
+
+// Manifest records the SHA-256 of every dumped file by its path relative to the root of the dump.
+type Manifest struct {
+	Objects map[string]string `json:"objects"`
+}
+
+// Changelog lists the objects that have changed since the previous dump by their paths relative to the root of the dump.
+type Changelog struct {
+	Added     []string `json:"added,omitempty"`
+	Modified  []string `json:"modified,omitempty"`
+	Deleted   []string `json:"deleted,omitempty"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// IncrementalWriter stores the dumped files in a directory that holds the previous dump. A file is only
+// written if its content hash differs from the one recorded by the manifest of the previous dump, and the
+// files of the previous dump that are not written again are deleted by Finish. The files that have not been
+// written by a dump, e.g. a kustomization.yaml, are never deleted. Only the objects, i.e. the YAML files,
+// are reported in the changelog.
+type IncrementalWriter struct {
+	dir string
+	// previous are the content hashes of the files of the previous dump by their relative paths. The files
+	// written by a dump that has failed have an empty hash, as their content is not known.
+	previous map[string]string
+
+	mu        sync.Mutex
+	current   map[string]string
+	changelog Changelog
+}
+
+// NewIncrementalWriter returns a writer for the dump in the directory. The files of the previous dump are
+// read from its manifest and from the journal of the dumps that have failed since.
+func NewIncrementalWriter(dir string) (*IncrementalWriter, error) {
+	w := &IncrementalWriter{
+		dir:      dir,
+		previous: make(map[string]string),
+		current:  make(map[string]string),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	switch {
+	case err == nil:
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("invalid manifest in %s: %w", dir, err)
+		}
+		maps.Copy(w.previous, m.Objects)
+	case errors.Is(err, os.ErrNotExist):
+		klog.V(3).Infoln("No manifest found in directory:", dir)
+	default:
+		return nil, err
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, manifestJournalFileName))
+	switch {
+	case err == nil:
+		klog.Infoln("Repairing the dump that has failed in directory:", dir)
+		for _, rel := range strings.Split(string(data), "\n") {
+			if rel != "" {
+				w.previous[rel] = ""
+			}
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	return w, os.MkdirAll(dir, os.ModePerm)
+}
+
+func (w *IncrementalWriter) Write(path string, data []byte) error {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	isObject := strings.HasSuffix(rel, ".yaml")
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	w.mu.Lock()
+	w.current[rel] = hash
+	w.mu.Unlock()
+
+	unchanged, existed, err := w.compare(path, rel, hash, data)
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		if isObject {
+			w.mu.Lock()
+			w.changelog.Unchanged++
+			w.mu.Unlock()
+		}
+		return nil
+	}
+	if err := w.journal(rel); err != nil {
+		return err
+	}
+	if err := NewFileWriter().Write(path, data); err != nil {
+		return err
+	}
+	if !isObject {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if existed {
+		w.changelog.Modified = append(w.changelog.Modified, rel)
+	} else {
+		w.changelog.Added = append(w.changelog.Added, rel)
+	}
+	return nil
+}
+
+// compare reports whether the file already has the content and whether it exists. The content is compared
+// with the hash recorded by the previous dump, or with the file on disk if the hash is not known.
+func (w *IncrementalWriter) compare(path, rel, hash string, data []byte) (bool, bool, error) {
+	if prev := w.previous[rel]; prev != "" {
+		_, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, false, err
+		}
+		return err == nil && prev == hash, err == nil, nil
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, false, err
+	}
+	return err == nil && bytes.Equal(existing, data), err == nil, nil
+}
+
+// journal records the path in the journal of the dump before the file is written.
+func (w *IncrementalWriter) journal(rel string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(w.dir, manifestJournalFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(rel + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the content of the file written by the previous dump.
+func (w *IncrementalWriter) Read(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+// Remove deletes a file of the previous dump that is not written by this dump.
+func (w *IncrementalWriter) Remove(path string) error {
+	if rel, err := filepath.Rel(w.dir, path); err == nil {
+		w.mu.Lock()
+		delete(w.current, filepath.ToSlash(rel))
+		w.mu.Unlock()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Finish deletes the files of the previous dumps that have not been written again, stores the manifest
+// of the dump and returns the changes since the previous dump.
+func (w *IncrementalWriter) Finish() (*Changelog, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, rel := range w.staleFiles() {
+		path := filepath.Join(w.dir, filepath.FromSlash(rel))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		w.removeEmptyDirs(filepath.Dir(path))
+		if strings.HasSuffix(rel, ".yaml") {
+			w.changelog.Deleted = append(w.changelog.Deleted, rel)
+		}
+	}
+
+	data, err := json.MarshalIndent(Manifest{Objects: w.current}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, ManifestFileName), data, 0o644); err != nil {
+		return nil, err
+	}
+	// the manifest records every written file from now on
+	if err := os.Remove(filepath.Join(w.dir, manifestJournalFileName)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	changelog := w.changelog
+	for _, paths := range [][]string{changelog.Added, changelog.Modified, changelog.Deleted} {
+		sort.Strings(paths)
+	}
+	return &changelog, nil
+}
+
+// staleFiles returns the files of the previous dumps that have not been written by this dump.
+func (w *IncrementalWriter) staleFiles() []string {
+	var stale []string
+	for rel := range w.previous {
+		// a path outside the dump is never deleted
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			continue
+		}
+		if _, ok := w.current[rel]; !ok {
+			stale = append(stale, rel)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// removeEmptyDirs removes the directory and its parents inside the dump until a directory is not empty.
+func (w *IncrementalWriter) removeEmptyDirs(dir string) {
+	for dir != w.dir && strings.HasPrefix(dir, w.dir) {
+		// Remove fails if the directory is not empty
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_IncrementalWriter(t *testing.T) {
+	dir := t.TempDir()
+	dump := func(files map[string]string) *Changelog {
+		t.Helper()
+		w, err := NewIncrementalWriter(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := w.Write(filepath.Join(dir, name), []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changelog, err := w.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changelog
+	}
+
+	// the files of the user are kept
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte("resources: []"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	first := dump(map[string]string{
+		"graph.json":                                   "{}",
+		"global/Namespace/demo.yaml":                   "a",
+		"namespaces/demo/ConfigMap/changed.yaml":       "a",
+		"namespaces/demo/ConfigMap/unchanged.yaml":     "a",
+		"namespaces/demo/Secret/deleted.yaml":          "a",
+		"namespaces/demo/ServiceAccount/deleted2.yaml": "a",
+	})
+	if len(first.Added) != 5 {
+		t.Errorf("first dump added %v, want every object", first.Added)
+	}
+
+	got := dump(map[string]string{
+		"global/Namespace/demo.yaml":               "a",
+		"namespaces/demo/ConfigMap/changed.yaml":   "b",
+		"namespaces/demo/ConfigMap/unchanged.yaml": "a",
+		"namespaces/demo/Secret/added.yaml":        "a",
+		"namespaces/demo/Secret/deleted.yaml.bak":  "not an object",
+	})
+	want := &Changelog{
+		Added:     []string{"namespaces/demo/Secret/added.yaml"},
+		Modified:  []string{"namespaces/demo/ConfigMap/changed.yaml"},
+		Deleted:   []string{"namespaces/demo/Secret/deleted.yaml", "namespaces/demo/ServiceAccount/deleted2.yaml"},
+		Unchanged: 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changelog = %+v, want %+v", got, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "namespaces/demo/Secret/deleted.yaml")); !os.IsNotExist(err) {
+		t.Error("deleted object still exists")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "namespaces/demo/ServiceAccount")); !os.IsNotExist(err) {
+		t.Error("empty directory of the deleted object still exists")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "namespaces/demo/ConfigMap/changed.yaml")); string(data) != "b" {
+		t.Errorf("changed object has %q, want %q", data, "b")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "graph.json")); !os.IsNotExist(err) {
+		t.Error("file that is not dumped anymore still exists")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kustomization.yaml")); err != nil {
+		t.Errorf("file of the user has been deleted: %v", err)
+	}
+}
+
+func Test_IncrementalWriter_hashes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ConfigMap/a.yaml": "a",
+		"ConfigMap/b.yaml": "b",
+		"ConfigMap/c.yaml": "c",
+	}
+	dump := func() *Changelog {
+		t.Helper()
+		w, err := NewIncrementalWriter(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			if err := w.Write(filepath.Join(dir, name), []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		changelog, err := w.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changelog
+	}
+	dump()
+
+	// the hash recorded for a is outdated and b has been removed from the disk
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	m.Objects["ConfigMap/a.yaml"] = "outdated"
+	if data, err = json.Marshal(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "ConfigMap/b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := dump()
+	want := &Changelog{
+		Added:     []string{"ConfigMap/b.yaml"},
+		Modified:  []string{"ConfigMap/a.yaml"},
+		Unchanged: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changelog = %+v, want %+v", got, want)
+	}
+}
+
+func Test_IncrementalWriter_interrupted(t *testing.T) {
+	dir := t.TempDir()
+	write := func(w *IncrementalWriter, files map[string]string) {
+		t.Helper()
+		for name, content := range files {
+			if err := w.Write(filepath.Join(dir, name), []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	w, err := NewIncrementalWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(w, map[string]string{"ConfigMap/a.yaml": "a", "ConfigMap/b.yaml": "b"})
+	if _, err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the dump fails after rewriting an object and adding another one
+	w, err = NewIncrementalWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(w, map[string]string{"ConfigMap/a.yaml": "failed", "ConfigMap/c.yaml": "c"})
+
+	w, err = NewIncrementalWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(w, map[string]string{"ConfigMap/a.yaml": "a", "ConfigMap/b.yaml": "b"})
+	got, err := w.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Changelog{
+		Modified:  []string{"ConfigMap/a.yaml"},
+		Deleted:   []string{"ConfigMap/c.yaml"},
+		Unchanged: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changelog = %+v, want %+v", got, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ConfigMap/a.yaml")); string(data) != "a" {
+		t.Errorf("object has %q from the failed dump, want %q", data, "a")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ConfigMap/c.yaml")); !os.IsNotExist(err) {
+		t.Error("object of the failed dump still exists")
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestJournalFileName)); !os.IsNotExist(err) {
+		t.Error("journal of the failed dump still exists")
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// IndexFileName is the name of the index of the dumped objects. It is written at the root of the dump.
+	IndexFileName = "index.json"
+	// IndexSignatureFileName is the name of the base64 encoded ed25519 signature of the index.
+	IndexSignatureFileName = "index.json.sig"
+)
+
+// Index lists the dumped objects sorted by their paths.
+type Index struct {
+	Objects []IndexEntry `json:"objects"`
+}
+
+// IndexEntry describes a dumped object. The UID and the resource version are the ones of the object
+// in the cluster, as they are removed from the sanitized object.
+type IndexEntry struct {
+	APIVersion      string    `json:"apiVersion"`
+	Kind            string    `json:"kind"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Name            string    `json:"name"`
+	UID             types.UID `json:"uid,omitempty"`
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	// Path is the file of the object relative to the root of the dump
+	Path string `json:"path"`
+	// SHA256 is the hex encoded SHA-256 of the file
+	SHA256 string `json:"sha256"`
+}
+
+// dumpIndex collects the index entries of the concurrent workers.
+type dumpIndex struct {
+	mu      sync.Mutex
+	dataDir string
+	entries []IndexEntry
+}
+
+func newDumpIndex(dataDir string) *dumpIndex {
+	return &dumpIndex{dataDir: dataDir}
+}
+
+// newIndexEntry describes the object before it is sanitized.
+func newIndexEntry(obj *unstructured.Unstructured) IndexEntry {
+	return IndexEntry{
+		APIVersion:      obj.GetAPIVersion(),
+		Kind:            obj.GetKind(),
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		UID:             obj.GetUID(),
+		ResourceVersion: obj.GetResourceVersion(),
+	}
+}
+
+// record adds the object stored in the file with the given content.
+func (idx *dumpIndex) record(entry IndexEntry, fileName string, data []byte) {
+	if idx == nil {
+		return
+	}
+	entry.Path = filepath.ToSlash(fileName)
+	if rel, err := filepath.Rel(idx.dataDir, fileName); err == nil && idx.dataDir != "" {
+		entry.Path = filepath.ToSlash(rel)
+	}
+	sum := sha256.Sum256(data)
+	entry.SHA256 = hex.EncodeToString(sum[:])
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries = append(idx.entries, entry)
+}
+
+// write stores the index at the root of the dump and signs it if a key is given. The signature of a previous
+// dump is removed from an unsigned index, as it does not match it anymore.
+func (idx *dumpIndex) write(storage Writer, key ed25519.PrivateKey) error {
+	idx.mu.Lock()
+	index := Index{Objects: append([]IndexEntry{}, idx.entries...)}
+	idx.mu.Unlock()
+	sort.Slice(index.Objects, func(i, j int) bool {
+		return index.Objects[i].Path < index.Objects[j].Path
+	})
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := storage.Write(filepath.Join(idx.dataDir, IndexFileName), data); err != nil {
+		return err
+	}
+	if key == nil {
+		if r, ok := storage.(Remover); ok {
+			return r.Remove(filepath.Join(idx.dataDir, IndexSignatureFileName))
+		}
+		return nil
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return storage.Write(filepath.Join(idx.dataDir, IndexSignatureFileName), []byte(sig))
+}
+
+// VerifyResult lists the problems found in a dumped tree. The paths are relative to the root of the dump.
+type VerifyResult struct {
+	// Verified is the number of objects that match the index
+	Verified int `json:"verified"`
+	// Signed is true if the signature of the index has been verified
+	Signed bool `json:"signed"`
+	// Missing are the indexed objects that don't exist in the tree
+	Missing []string `json:"missing,omitempty"`
+	// Modified are the objects whose content does not match the index
+	Modified []string `json:"modified,omitempty"`
+	// Unindexed are the objects of the tree that are not in the index
+	Unindexed []string `json:"unindexed,omitempty"`
+}
+
+// Valid reports whether the tree matches the index.
+func (r *VerifyResult) Valid() bool {
+	return len(r.Missing) == 0 && len(r.Modified) == 0 && len(r.Unindexed) == 0
+}
+
+// VerifyIndex checks the dumped tree in the directory against its index. If a public key is given, the
+// signature of the index is verified before the objects are checked.
+func VerifyIndex(dir string, key ed25519.PublicKey) (*VerifyResult, error) {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFileName))
+	if err != nil {
+		return nil, err
+	}
+	result := &VerifyResult{}
+	if key != nil {
+		sig, err := os.ReadFile(filepath.Join(dir, IndexSignatureFileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.New("the index is not signed")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signature of the index: %w", err)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature of the index: %w", err)
+		}
+		if !ed25519.Verify(key, data, raw) {
+			return nil, errors.New("the signature of the index does not match")
+		}
+		result.Signed = true
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index: %w", err)
+	}
+	indexed := make(map[string]bool, len(index.Objects))
+	for _, entry := range index.Objects {
+		indexed[entry.Path] = true
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(entry.Path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			result.Missing = append(result.Missing, entry.Path)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			result.Modified = append(result.Modified, entry.Path)
+			continue
+		}
+		result.Verified++
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !indexed[filepath.ToSlash(rel)] {
+			result.Unindexed = append(result.Unindexed, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_VerifyIndex(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewFileWriter()
+	index := newDumpIndex(dir)
+	for _, name := range []string{"a", "b"} {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("demo")
+		obj.SetName(name)
+		obj.SetUID(types.UID("uid-" + name))
+		fileName := filepath.Join(dir, "ConfigMap", name+".yaml")
+		data, err := storeItem(fileName, obj.Object, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.record(newIndexEntry(obj), fileName, data)
+	}
+	if err := index.write(storage, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := VerifyIndex(dir, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() || !result.Signed || result.Verified != 2 {
+		t.Errorf("result = %+v, want 2 verified objects and a verified signature", result)
+	}
+
+	// the signature of the previous dump is removed from an unsigned index
+	if err := index.write(storage, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, IndexSignatureFileName)); !os.IsNotExist(err) {
+		t.Error("signature of the previous dump still exists")
+	}
+	if _, err := VerifyIndex(dir, publicKey); err == nil {
+		t.Error("expected an error for the unsigned index")
+	}
+	if err := index.write(storage, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyIndex(dir, otherKey); err == nil {
+		t.Error("expected an error for the signature of another key")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "ConfigMap", "a.yaml"), []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "ConfigMap", "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ConfigMap", "c.yaml"), []byte("extra"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = VerifyIndex(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &VerifyResult{
+		Missing:   []string{"ConfigMap/b.yaml"},
+		Modified:  []string{"ConfigMap/a.yaml"},
+		Unindexed: []string{"ConfigMap/c.yaml"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+}
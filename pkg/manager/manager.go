@@ -17,17 +17,25 @@ limitations under the License.
 package manager
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"stash.appscode.dev/apimachinery/apis"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/kubedump/pkg/sanitizers"
 
 	"k8s.io/client-go/rest"
 )
 
 type BackupManager interface {
-	Dump() error
+	Dump() (*BackupReport, error)
 }
 
 type BackupOptions struct {
@@ -38,10 +46,49 @@ type BackupOptions struct {
 	Target            v1beta1.TargetRef
 	IncludeDependants bool
 	IgnoreGroupKinds  []string
-	Storage           Writer
+	// IncludeResources and ExcludeResources are wildcard patterns over the group, version, resource and kind
+	// (i.e. apps/*, *.metrics.k8s.io) that select the resources to dump
+	IncludeResources []string
+	ExcludeResources []string
+	// IncludeNamespaces and ExcludeNamespaces are glob patterns and NamespaceSelector is a label selector that
+	// select the namespaces of a cluster backup. Cluster scoped resources are dumped regardless.
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	NamespaceSelector string
+	// Namespaces are the namespaces of a multi-namespace target. Their namespaced resources and Namespace objects
+	// are dumped under namespaces/<namespace> and global/Namespace respectively.
+	Namespaces []string
+	// IncludeClusterDependencies adds the cluster scoped objects referenced by the dumped objects of a namespace
+	// backup, i.e. the Namespace, CRDs, ClusterRoles, PersistentVolumes, StorageClasses, PriorityClasses and IngressClasses
+	IncludeClusterDependencies bool
+	// IncludeReferences adds the objects referenced by the pod specs of an application backup (i.e. ConfigMaps,
+	// Secrets, PersistentVolumeClaims and ServiceAccounts) together with the Services and PodDisruptionBudgets
+	// selecting its pods and the HorizontalPodAutoscalers scaling it
+	IncludeReferences bool
+	// AppSelector is the label selector (i.e. app.kubernetes.io/instance=shop) of the components of a label based
+	// application. The components are collected from the namespace of the target, or from the whole cluster if it is empty.
+	AppSelector string
+	Storage     Writer
+	// Concurrency is the maximum number of resources listed in parallel
+	Concurrency int
+	// Strict fails the backup when an API group can't be discovered or a resource can't be listed.
+	// Otherwise, they are skipped and reported as warnings.
+	Strict bool
+	// Consistency is the resourceVersionMatch (NotOlderThan or Exact) used to list every resource at the
+	// resource version of the start of the dump. The resources are listed at their latest version if it is empty.
+	Consistency string
+	// SigningKey signs the index of the dump, if set
+	SigningKey ed25519.PrivateKey
+	// EncryptionKey encrypts the data of the dumped Secrets, if set. It must be 32 bytes long.
+	EncryptionKey []byte
+	// SecretPolicy decides whether the Secrets are dumped as they are, redacted or skipped, whether they are sanitized or not
+	SecretPolicy sanitizers.SecretPolicy
 }
 
 func NewBackupManager(opt BackupOptions) BackupManager {
+	if opt.AppSelector != "" {
+		return newApplicationBackupManager(opt)
+	}
 	switch opt.Target.Kind {
 	case v1beta1.TargetKindEmpty, apis.KindNamespace:
 		return newGenericResourceBackupManager(opt)
@@ -50,10 +97,21 @@ func NewBackupManager(opt BackupOptions) BackupManager {
 	}
 }
 
+// Writer stores the dumped items. Write can be called concurrently.
 type Writer interface {
 	Write(string, []byte) error
 }
 
+// Reader is implemented by the writers that can read back the items stored by the previous dump.
+type Reader interface {
+	Read(string) ([]byte, error)
+}
+
+// Remover is implemented by the writers that can remove a file left by the previous dump.
+type Remover interface {
+	Remove(string) error
+}
+
 type fileWriter struct{}
 
 func NewFileWriter() Writer {
@@ -68,3 +126,54 @@ func (w fileWriter) Write(path string, data []byte) error {
 	}
 	return os.WriteFile(path, data, 0o644)
 }
+
+func (w fileWriter) Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type WriteCloser interface {
+	Writer
+	Close() error
+}
+
+// tarWriter writes the dumped items into a gzip compressed tar archive.
+type tarWriter struct {
+	mu sync.Mutex
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func NewTarWriter(w io.Writer) WriteCloser {
+	gw := gzip.NewWriter(w)
+	return &tarWriter{
+		gw: gw,
+		tw: tar.NewWriter(gw),
+	}
+}
+
+func (w *tarWriter) Write(path string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.tw.WriteHeader(&tar.Header{
+		Name:    strings.TrimPrefix(filepath.ToSlash(path), "/"),
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(data)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gw.Close()
+}
@@ -127,7 +127,7 @@ func Test_Dump(t *testing.T) {
 			tt.options.Storage = manager.NewFileWriter()
 
 			mgr := manager.NewBackupManager(tt.options)
-			if err := mgr.Dump(); (err != nil) != tt.wantErr {
+			if _, err := mgr.Dump(); (err != nil) != tt.wantErr {
 				t.Errorf("Dump() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
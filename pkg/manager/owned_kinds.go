@@ -0,0 +1,61 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ownedKinds are the kinds of the objects that the built-in controllers create for an object of the given kind.
+// A kind that maps to nothing owns no object. The kinds that are not listed here, i.e. the custom resources,
+// may own objects of any kind.
+var ownedKinds = map[schema.GroupKind][]schema.GroupKind{
+	{Group: "apps", Kind: "Deployment"}:                {{Group: "apps", Kind: "ReplicaSet"}},
+	{Group: "apps", Kind: "ReplicaSet"}:                {{Kind: "Pod"}},
+	{Kind: "ReplicationController"}:                    {{Kind: "Pod"}},
+	{Group: "apps", Kind: "StatefulSet"}:               {{Kind: "Pod"}, {Group: "apps", Kind: "ControllerRevision"}, {Kind: "PersistentVolumeClaim"}},
+	{Group: "apps", Kind: "DaemonSet"}:                 {{Kind: "Pod"}, {Group: "apps", Kind: "ControllerRevision"}},
+	{Group: "batch", Kind: "CronJob"}:                  {{Group: "batch", Kind: "Job"}},
+	{Group: "batch", Kind: "Job"}:                      {{Kind: "Pod"}},
+	{Kind: "Pod"}:                                      {{Kind: "PersistentVolumeClaim"}},
+	{Kind: "Service"}:                                  {{Kind: "Endpoints"}, {Group: "discovery.k8s.io", Kind: "EndpointSlice"}},
+	{Kind: "ConfigMap"}:                                nil,
+	{Kind: "Secret"}:                                   nil,
+	{Kind: "PersistentVolumeClaim"}:                    nil,
+	{Kind: "ServiceAccount"}:                           nil,
+	{Kind: "Endpoints"}:                                nil,
+	{Group: "discovery.k8s.io", Kind: "EndpointSlice"}: nil,
+	{Group: "apps", Kind: "ControllerRevision"}:        nil,
+	// the components of an Application are collected by its selector, not by their owner references
+	applicationGroupKind: nil,
+}
+
+// canBeOwned reports whether an object of the kind can be owned by an object of any of the owner kinds.
+func canBeOwned(kind schema.GroupKind, owners map[schema.GroupKind]bool) bool {
+	for owner := range owners {
+		owned, known := ownedKinds[owner]
+		if !known {
+			return true
+		}
+		for _, gk := range owned {
+			if gk == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_canBeOwned(t *testing.T) {
+	deployment := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+	replicaSet := schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}
+	pod := schema.GroupKind{Kind: "Pod"}
+	secret := schema.GroupKind{Kind: "Secret"}
+	custom := schema.GroupKind{Group: "kubedb.com", Kind: "MongoDB"}
+
+	tests := []struct {
+		name   string
+		kind   schema.GroupKind
+		owners []schema.GroupKind
+		want   bool
+	}{
+		{name: "owned kind", kind: replicaSet, owners: []schema.GroupKind{deployment}, want: true},
+		{name: "not owned kind", kind: pod, owners: []schema.GroupKind{deployment}, want: false},
+		{name: "owner owns nothing", kind: pod, owners: []schema.GroupKind{secret}, want: false},
+		{name: "any of the owners", kind: pod, owners: []schema.GroupKind{deployment, replicaSet}, want: true},
+		{name: "custom owner", kind: secret, owners: []schema.GroupKind{custom}, want: true},
+		{name: "no owner", kind: pod, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owners := make(map[schema.GroupKind]bool)
+			for _, gk := range tt.owners {
+				owners[gk] = true
+			}
+			if got := canBeOwned(tt.kind, owners); got != tt.want {
+				t.Errorf("canBeOwned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
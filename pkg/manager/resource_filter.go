@@ -0,0 +1,149 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// resourcePattern matches resources using glob patterns. It is written in one of the following forms:
+//
+//	<resource>[.<group>]           i.e. deployments.apps, *.metrics.k8s.io, pods
+//	<group>/<resource>             i.e. apps/*, batch/cronjobs
+//	<group>/<version>/<resource>   i.e. apps/v1/*, core/v1/secrets
+//
+// The resource part matches either the plural resource name or the kind. The core group is written as "core".
+// The group is not compared when it is omitted in the first form. The group part is a glob as well, and a
+// pattern of the first form that starts with *. also matches every resource of the groups that match it as
+// a whole, i.e. *.metrics.k8s.io matches the resources of metrics.k8s.io and custom.metrics.k8s.io.
+type resourcePattern struct {
+	group    string
+	anyGroup bool
+	// groups matches the groups of which every resource is matched
+	groups   string
+	version  string
+	resource string
+}
+
+func parseResourcePattern(s string) (resourcePattern, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return resourcePattern{}, fmt.Errorf("empty resource pattern")
+	}
+
+	var p resourcePattern
+	parts := strings.Split(s, "/")
+	switch len(parts) {
+	case 1:
+		p.version = "*"
+		p.resource, p.group, _ = strings.Cut(s, ".")
+		p.anyGroup = p.group == ""
+		if p.resource == "*" && !p.anyGroup {
+			p.groups = s
+		}
+	case 2:
+		p.group, p.version, p.resource = parts[0], "*", parts[1]
+	case 3:
+		p.group, p.version, p.resource = parts[0], parts[1], parts[2]
+	default:
+		return resourcePattern{}, fmt.Errorf("invalid resource pattern %q", s)
+	}
+	if p.group == "core" {
+		p.group = ""
+	}
+	for _, glob := range []string{p.group, p.version, p.resource} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return resourcePattern{}, fmt.Errorf("invalid resource pattern %q: %w", s, err)
+		}
+	}
+	return p, nil
+}
+
+func (p resourcePattern) matches(gvr schema.GroupVersionResource, kind string) bool {
+	if p.groups != "" && globMatch(p.groups, gvr.Group) {
+		return true
+	}
+	if !p.anyGroup && !globMatch(p.group, gvr.Group) {
+		return false
+	}
+	if !globMatch(p.version, gvr.Version) {
+		return false
+	}
+	return globMatch(p.resource, gvr.Resource) || globMatch(p.resource, strings.ToLower(kind))
+}
+
+func globMatch(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// resourceFilter selects the resources to process. A resource is selected if it matches any of the include
+// patterns, or there is no include pattern, and it does not match any of the exclude patterns.
+type resourceFilter struct {
+	include []resourcePattern
+	exclude []resourcePattern
+}
+
+func newResourceFilter(include, exclude []string) (*resourceFilter, error) {
+	var (
+		f   resourceFilter
+		err error
+	)
+	if f.include, err = parseResourcePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseResourcePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseResourcePatterns(in []string) ([]resourcePattern, error) {
+	out := make([]resourcePattern, 0, len(in))
+	for _, s := range in {
+		p, err := parseResourcePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// allows reports whether the resource is selected. A nil filter selects every resource.
+func (f *resourceFilter) allows(gvr schema.GroupVersionResource, kind string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchesAnyResource(f.include, gvr, kind) {
+		return false
+	}
+	return !matchesAnyResource(f.exclude, gvr, kind)
+}
+
+func matchesAnyResource(patterns []resourcePattern, gvr schema.GroupVersionResource, kind string) bool {
+	for _, p := range patterns {
+		if p.matches(gvr, kind) {
+			return true
+		}
+	}
+	return false
+}
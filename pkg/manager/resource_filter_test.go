@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_resourceFilter_allows(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	cronjobs := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	podMetrics := schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+	customMetrics := schema.GroupVersionResource{Group: "custom.metrics.k8s.io", Version: "v1beta2", Resource: "pods"}
+	snapshots := schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		allowed []schema.GroupVersionResource
+		denied  []schema.GroupVersionResource
+	}{
+		{
+			name:    "no patterns",
+			allowed: []schema.GroupVersionResource{deployments, cronjobs, secrets, podMetrics, customMetrics},
+		},
+		{
+			name:    "include groups",
+			include: []string{"apps/*", "batch/*"},
+			allowed: []schema.GroupVersionResource{deployments, cronjobs},
+			denied:  []schema.GroupVersionResource{secrets, podMetrics},
+		},
+		{
+			name:    "exclude group suffix",
+			exclude: []string{"*.metrics.k8s.io"},
+			allowed: []schema.GroupVersionResource{deployments, cronjobs, secrets, snapshots},
+			denied:  []schema.GroupVersionResource{podMetrics, customMetrics},
+		},
+		{
+			name:    "group glob",
+			include: []string{"*.*.k8s.io/*", "volumesnapshots.snapshot.*"},
+			allowed: []schema.GroupVersionResource{customMetrics, snapshots},
+			denied:  []schema.GroupVersionResource{podMetrics, deployments, secrets},
+		},
+		{
+			name:    "resource in any group",
+			include: []string{"pods", "Deployment"},
+			allowed: []schema.GroupVersionResource{deployments, podMetrics},
+			denied:  []schema.GroupVersionResource{cronjobs, secrets},
+		},
+		{
+			name:    "core group with version",
+			include: []string{"core/v1/*"},
+			exclude: []string{"secrets"},
+			allowed: []schema.GroupVersionResource{configMaps},
+			denied:  []schema.GroupVersionResource{secrets, deployments, podMetrics},
+		},
+	}
+	kinds := map[schema.GroupVersionResource]string{
+		deployments:   "Deployment",
+		cronjobs:      "CronJob",
+		secrets:       "Secret",
+		configMaps:    "ConfigMap",
+		podMetrics:    "PodMetrics",
+		customMetrics: "MetricValueList",
+		snapshots:     "VolumeSnapshot",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newResourceFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, gvr := range tt.allowed {
+				if !f.allows(gvr, kinds[gvr]) {
+					t.Errorf("expected %s to be allowed", gvr)
+				}
+			}
+			for _, gvr := range tt.denied {
+				if f.allows(gvr, kinds[gvr]) {
+					t.Errorf("expected %s to be denied", gvr)
+				}
+			}
+		})
+	}
+}
@@ -18,7 +18,13 @@ package manager
 
 import (
 	"context"
+	"fmt"
+	"path"
+	"sync/atomic"
+	"time"
 
+	"golang.org/x/sync/errgroup"
+	"gomodules.xyz/sets"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -39,25 +45,144 @@ type resourceProcessor struct {
 	namespace        string
 	selector         string
 	ignoreGroupKinds []string
+	// includeResources and excludeResources are the resource patterns that select the resources to process
+	includeResources []string
+	excludeResources []string
+	resourceFilter   *resourceFilter
+	// requiredResources are resource patterns that a resource must match in addition to the resource filter,
+	// e.g. the component kinds of an Application
+	requiredResources []string
+	requiredFilter    *resourceFilter
+	// includeNamespaces, excludeNamespaces and namespaceSelector select the namespaces in which the namespaced
+	// resources are listed. They are ignored when the target is a single namespace.
+	includeNamespaces []string
+	excludeNamespaces []string
+	namespaceSelector string
+	// targetNamespaces are the namespaces of a multi-namespace target. Only their namespaced resources and
+	// Namespace objects are processed and the namespace filters are ignored.
+	targetNamespaces []string
+	// namespaces are the selected namespaces. It is nil when the namespaces are not filtered.
+	namespaces sets.String
+	// concurrency is the maximum number of resources listed in parallel
+	concurrency int
+	// strict fails the processing when an API group can't be discovered or a resource can't be listed.
+	// Otherwise, they are skipped and recorded as warnings.
+	strict   bool
+	warnings warningRecorder
+	stats    *backupStats
+	// consistency lists the resources at the resource version of the start of the dump, if set
+	consistency *consistency
 }
 
+// resourceTask lists a resource in a single namespace, or in all namespaces if the namespace is empty.
+type resourceTask struct {
+	gvr       schema.GroupVersionResource
+	kind      string
+	namespace string
+}
+
+// itemProcessor processes the listed items. Process can be called concurrently for different resources.
 type itemProcessor interface {
 	Process(items []unstructured.Unstructured, gvr schema.GroupVersionResource) error
 }
 
-func (opt *resourceProcessor) processAPIResources() error {
-	err := opt.configure()
+// processAPIResources processes every selected resource and returns the warnings for the skipped ones.
+func (opt *resourceProcessor) processAPIResources() ([]DumpWarning, error) {
+	tasks, err := opt.discoverResources()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := opt.processResources(tasks); err != nil {
+		return nil, err
+	}
+	return opt.warnings.list(), nil
+}
+
+// discoverResources configures the clients and returns the tasks that list the selected resources.
+func (opt *resourceProcessor) discoverResources() ([]resourceTask, error) {
+	var err error
+	opt.resourceFilter, err = newResourceFilter(opt.includeResources, opt.excludeResources)
+	if err != nil {
+		return nil, err
+	}
+	opt.requiredFilter, err = newResourceFilter(opt.requiredResources, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = opt.configure()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := opt.namespace
+	if len(opt.targetNamespaces) > 0 {
+		namespace = opt.targetNamespaces[0]
+	}
+	err = opt.consistency.start(opt.di, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	err = opt.selectNamespaces()
+	if err != nil {
+		return nil, err
 	}
 
 	resList, err := opt.disc.ServerPreferredResources()
 	if err != nil {
-		return err
+		if opt.strict || !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, err
+		}
+		// the resources of the healthy groups are still returned
+		for gv, reason := range err.(*discovery.ErrGroupDiscoveryFailed).Groups {
+			klog.Warningf("Skipping API group %s as its discovery failed: %v", gv, reason)
+			opt.warnings.record(DumpWarning{GroupVersion: gv.String(), Reason: reason.Error()})
+		}
 	}
 
+	var tasks []resourceTask
 	for _, group := range resList {
-		err := opt.processGroup(group)
+		selected, err := opt.selectResources(group)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, selected...)
+	}
+	return tasks, nil
+}
+
+// processResources lists the resources using a bounded pool of workers. Once a resource fails, the
+// resources after it in the given order are skipped, while the ones before it are still processed. So the
+// error of the first failed resource in the given order is returned, whatever the scheduling of the workers.
+func (opt *resourceProcessor) processResources(tasks []resourceTask) error {
+	var eg errgroup.Group
+	eg.SetLimit(max(opt.concurrency, 1))
+
+	// failed is the index of the first failed task so far
+	var failed atomic.Int64
+	failed.Store(int64(len(tasks)))
+	errs := make([]error, len(tasks))
+	for i := range tasks {
+		eg.Go(func() error {
+			if int64(i) > failed.Load() {
+				return nil
+			}
+			if err := opt.processResourceInstances(context.TODO(), tasks[i]); err != nil {
+				errs[i] = err
+				for {
+					cur := failed.Load()
+					if int64(i) >= cur || failed.CompareAndSwap(cur, int64(i)) {
+						break
+					}
+				}
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
 			return err
 		}
@@ -65,7 +190,11 @@ func (opt *resourceProcessor) processAPIResources() error {
 	return nil
 }
 
+// configure creates the clients of the processor. The clients that are already set are kept.
 func (opt *resourceProcessor) configure() error {
+	if opt.disc != nil && opt.di != nil {
+		return nil
+	}
 	var err error
 	opt.config.QPS = 1e6
 	opt.config.Burst = 1e6
@@ -89,12 +218,55 @@ func (opt *resourceProcessor) configure() error {
 	return nil
 }
 
-func (opt *resourceProcessor) processGroup(group *metav1.APIResourceList) error {
-	gv, err := schema.ParseGroupVersion(group.GroupVersion)
+// selectNamespaces resolves the namespaces that match the namespace filters.
+func (opt *resourceProcessor) selectNamespaces() error {
+	if len(opt.targetNamespaces) > 0 {
+		opt.namespaces = sets.NewString(opt.targetNamespaces...)
+		return nil
+	}
+	if opt.namespace != "" || (len(opt.includeNamespaces) == 0 && len(opt.excludeNamespaces) == 0 && opt.namespaceSelector == "") {
+		return nil
+	}
+	for _, patterns := range [][]string{opt.includeNamespaces, opt.excludeNamespaces} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid namespace pattern %q: %w", p, err)
+			}
+		}
+	}
+
+	list, err := opt.di.Resource(namespaceGVR).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: opt.namespaceSelector,
+	})
 	if err != nil {
 		return err
 	}
+	opt.namespaces = sets.NewString()
+	for _, ns := range list.Items {
+		if opt.namespaceMatches(ns.GetName()) {
+			opt.namespaces.Insert(ns.GetName())
+		}
+	}
+	klog.Infoln("Selected namespaces:", opt.namespaces.List())
+	return nil
+}
 
+func (opt *resourceProcessor) namespaceMatches(name string) bool {
+	if len(opt.includeNamespaces) > 0 && !matchesAnyName(name, opt.includeNamespaces) {
+		return false
+	}
+	return !matchesAnyName(name, opt.excludeNamespaces)
+}
+
+// selectResources returns the resources of the group that will be processed. When the namespaces are
+// filtered, a namespaced resource is listed separately in every selected namespace.
+func (opt *resourceProcessor) selectResources(group *metav1.APIResourceList) ([]resourceTask, error) {
+	gv, err := schema.ParseGroupVersion(group.GroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []resourceTask
 	for _, res := range group.APIResources {
 		if isSubResource(res.Name) || !hasGetListVerbs(res.Verbs) {
 			continue
@@ -103,17 +275,28 @@ func (opt *resourceProcessor) processGroup(group *metav1.APIResourceList) error
 		if !res.Namespaced && opt.namespace != "" {
 			continue
 		}
+		// only the Namespace objects are processed from the non-namespaced resources of a multi-namespace target
+		if !res.Namespaced && len(opt.targetNamespaces) > 0 && gv.WithResource(res.Name) != namespaceGVR {
+			continue
+		}
 
 		if opt.shouldIgnoreResource(schema.GroupKind{Group: gv.WithResource(res.Name).Group, Kind: res.Kind}) {
 			continue
 		}
+		if !opt.resourceFilter.allows(gv.WithResource(res.Name), res.Kind) || !opt.requiredFilter.allows(gv.WithResource(res.Name), res.Kind) {
+			continue
+		}
 
-		err := opt.processResourceInstances(gv.WithResource(res.Name))
-		if err != nil {
-			return err
+		gvr := gv.WithResource(res.Name)
+		if !res.Namespaced || opt.namespaces == nil {
+			tasks = append(tasks, resourceTask{gvr: gvr, kind: res.Kind, namespace: opt.namespace})
+			continue
+		}
+		for _, ns := range opt.namespaces.List() {
+			tasks = append(tasks, resourceTask{gvr: gvr, kind: res.Kind, namespace: ns})
 		}
 	}
-	return nil
+	return tasks, nil
 }
 
 func (opt *resourceProcessor) shouldIgnoreResource(gk schema.GroupKind) bool {
@@ -125,30 +308,62 @@ func (opt *resourceProcessor) shouldIgnoreResource(gk schema.GroupKind) bool {
 	return false
 }
 
-func (opt *resourceProcessor) processResourceInstances(gvr schema.GroupVersionResource) error {
-	klog.V(5).Infoln("Processing:", gvr)
-	var next string
+func (opt *resourceProcessor) processResourceInstances(ctx context.Context, task resourceTask) error {
+	gvr := task.gvr
+	klog.V(5).Infoln("Processing:", gvr, task.namespace)
+	var (
+		next     string
+		fallback bool
+	)
 	for {
 		var ri dynamic.ResourceInterface
-		if opt.namespace != "" {
-			ri = opt.di.Resource(gvr).Namespace(opt.namespace)
+		if task.namespace != "" {
+			ri = opt.di.Resource(gvr).Namespace(task.namespace)
 		} else {
 			ri = opt.di.Resource(gvr)
 		}
 
-		resp, err := ri.List(context.TODO(), metav1.ListOptions{
+		start := time.Now()
+		listOpts := metav1.ListOptions{
 			Limit:         250,
 			Continue:      next,
 			LabelSelector: opt.selector,
-		})
+		}
+		if !fallback {
+			opt.consistency.listOptions(&listOpts)
+		}
+		resp, err := ri.List(ctx, listOpts)
+		if err != nil && !fallback && next == "" && opt.consistency.shouldFallback(err) {
+			opt.consistency.recordFallback(gvr, task.namespace, err)
+			fallback = true
+			continue
+		}
 		if err != nil {
-			if !kerr.IsNotFound(err) {
+			if kerr.IsNotFound(err) {
+				return nil
+			}
+			opt.stats.recordList(gvr, time.Since(start), err)
+			if opt.strict || ctx.Err() != nil {
 				return err
 			}
+			klog.Warningf("Skipping resource %s as it can't be listed: %v", gvr, err)
+			opt.warnings.record(DumpWarning{
+				GroupVersion: gvr.GroupVersion().String(),
+				Resource:     gvr.Resource,
+				Namespace:    task.namespace,
+				Reason:       err.Error(),
+			})
 			return nil
 		}
 
-		err = opt.itemProcessor.Process(resp.Items, gvr)
+		opt.stats.recordList(gvr, time.Since(start), nil)
+
+		items := resp.Items
+		if gvr == namespaceGVR && opt.namespaces != nil {
+			items = opt.filterNamespaces(items)
+		}
+		opt.consistency.check(items, gvr)
+		err = opt.itemProcessor.Process(items, gvr)
 		if err != nil {
 			return err
 		}
@@ -160,3 +375,16 @@ func (opt *resourceProcessor) processResourceInstances(gvr schema.GroupVersionRe
 	}
 	return nil
 }
+
+// filterNamespaces keeps only the Namespace objects of the selected namespaces.
+func (opt *resourceProcessor) filterNamespaces(items []unstructured.Unstructured) []unstructured.Unstructured {
+	out := make([]unstructured.Unstructured, 0, len(items))
+	for _, ns := range items {
+		if opt.namespaces.Has(ns.GetName()) {
+			out = append(out, ns)
+		} else {
+			opt.stats.recordSkipped(namespaceGVR)
+		}
+	}
+	return out
+}
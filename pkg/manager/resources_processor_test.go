@@ -0,0 +1,417 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// newNamespaceServer serves the Namespaces with the given labels, filtered by the label selector of the request.
+// It counts the requests in lists.
+func newNamespaceServer(t *testing.T, namespaces map[string]map[string]string, lists *int) dynamic.Interface {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*lists++
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var items []any
+		for name, l := range namespaces {
+			if selector.Matches(labels.Set(l)) {
+				items = append(items, map[string]any{
+					"apiVersion": "v1",
+					"kind":       "Namespace",
+					"metadata":   map[string]any{"name": name, "labels": l},
+				})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"apiVersion": "v1",
+			"kind":       "NamespaceList",
+			"metadata":   map[string]any{},
+			"items":      items,
+		})
+	}))
+	t.Cleanup(server.Close)
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return di
+}
+
+func Test_selectNamespaces(t *testing.T) {
+	namespaces := map[string]map[string]string{
+		"default":     {"env": "prod"},
+		"kube-system": {},
+		"team-a":      {"env": "prod"},
+		"team-b":      {"env": "dev"},
+		"team-c":      {"env": "prod"},
+	}
+	tests := []struct {
+		name    string
+		opt     *resourceProcessor
+		want    []string
+		noList  bool
+		wantErr bool
+	}{
+		{
+			name:   "no filters",
+			noList: true,
+		},
+		{
+			name:   "single namespace target ignores the filters",
+			opt:    &resourceProcessor{namespace: "demo", includeNamespaces: []string{"team-*"}},
+			noList: true,
+		},
+		{
+			name:   "multi-namespace target ignores the filters",
+			opt:    &resourceProcessor{targetNamespaces: []string{"team-b", "demo"}, excludeNamespaces: []string{"team-*"}},
+			want:   []string{"demo", "team-b"},
+			noList: true,
+		},
+		{
+			name: "include",
+			opt:  &resourceProcessor{includeNamespaces: []string{"team-*", "default"}},
+			want: []string{"default", "team-a", "team-b", "team-c"},
+		},
+		{
+			name: "exclude",
+			opt:  &resourceProcessor{excludeNamespaces: []string{"kube-*"}},
+			want: []string{"default", "team-a", "team-b", "team-c"},
+		},
+		{
+			name: "exclude wins over include",
+			opt:  &resourceProcessor{includeNamespaces: []string{"team-*"}, excludeNamespaces: []string{"team-b"}},
+			want: []string{"team-a", "team-c"},
+		},
+		{
+			name: "selector",
+			opt:  &resourceProcessor{namespaceSelector: "env=prod"},
+			want: []string{"default", "team-a", "team-c"},
+		},
+		{
+			name: "selector and include",
+			opt:  &resourceProcessor{namespaceSelector: "env=prod", includeNamespaces: []string{"team-*"}},
+			want: []string{"team-a", "team-c"},
+		},
+		{
+			name: "nothing selected",
+			opt:  &resourceProcessor{namespaceSelector: "env=staging"},
+			want: []string{},
+		},
+		{
+			name:    "invalid include pattern",
+			opt:     &resourceProcessor{includeNamespaces: []string{"team-["}},
+			noList:  true,
+			wantErr: true,
+		},
+		{
+			name:    "invalid exclude pattern",
+			opt:     &resourceProcessor{excludeNamespaces: []string{"[]"}},
+			noList:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lists := 0
+			opt := tt.opt
+			if opt == nil {
+				opt = &resourceProcessor{}
+			}
+			opt.di = newNamespaceServer(t, namespaces, &lists)
+
+			err := opt.selectNamespaces()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectNamespaces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.noList != (lists == 0) {
+				t.Errorf("Namespaces listed %d times", lists)
+			}
+			if tt.want == nil {
+				if opt.namespaces != nil {
+					t.Errorf("namespaces = %v, want nil", opt.namespaces.List())
+				}
+				return
+			}
+			if got := opt.namespaces.List(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("namespaces = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// partialDiscovery returns the resources of the core group and fails the discovery of the metrics group.
+type partialDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+var metricsGV = schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+
+func (d partialDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"get", "list"}},
+				{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: []string{"get", "list"}},
+			},
+		},
+	}, &discovery.ErrGroupDiscoveryFailed{
+		Groups: map[schema.GroupVersion]error{metricsGV: errors.New("the server is currently unable to handle the request")},
+	}
+}
+
+// namesRecorder records the names of the processed items.
+type namesRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (r *namesRecorder) Process(items []unstructured.Unstructured, _ schema.GroupVersionResource) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range items {
+		r.names = append(r.names, item.GetName())
+	}
+	return nil
+}
+
+func Test_discoverResources(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		opt := &resourceProcessor{
+			disc:   partialDiscovery{&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}},
+			di:     newNamespaceServer(t, nil, new(int)),
+			strict: strict,
+		}
+		tasks, err := opt.discoverResources()
+		if strict {
+			if !discovery.IsGroupDiscoveryFailedError(err) {
+				t.Errorf("strict: discoverResources() error = %v, want the discovery error", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resources []string
+		for _, task := range tasks {
+			resources = append(resources, task.gvr.Resource)
+		}
+		if want := []string{"configmaps", "secrets"}; !reflect.DeepEqual(resources, want) {
+			t.Errorf("resources = %v, want %v", resources, want)
+		}
+		want := []DumpWarning{{GroupVersion: metricsGV.String(), Reason: "the server is currently unable to handle the request"}}
+		if got := opt.warnings.list(); !reflect.DeepEqual(got, want) {
+			t.Errorf("warnings = %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_processResources_listFailure(t *testing.T) {
+	// the ConfigMaps are listed, the Secrets are forbidden and the Leases are not served
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/namespaces/demo/configmaps":
+			_, _ = w.Write([]byte(`{"kind":"ConfigMapList","apiVersion":"v1","metadata":{},"items":[{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config","namespace":"demo"}}]}`))
+		case "/api/v1/namespaces/demo/secrets":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"secrets is forbidden","reason":"Forbidden","code":403}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`))
+		}
+	}))
+	defer server.Close()
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := []resourceTask{
+		{gvr: configMapGVR, kind: "ConfigMap", namespace: "demo"},
+		{gvr: secretGVR, kind: "Secret", namespace: "demo"},
+		{gvr: schema.GroupVersionResource{Group: "coordination.k8s.io", Version: "v1", Resource: "leases"}, kind: "Lease", namespace: "demo"},
+	}
+
+	for _, strict := range []bool{false, true} {
+		recorder := &namesRecorder{}
+		opt := &resourceProcessor{
+			di:            di,
+			itemProcessor: recorder,
+			strict:        strict,
+			stats:         newBackupStats(),
+		}
+		err := opt.processResources(tasks)
+		if strict {
+			if !kerr.IsForbidden(err) {
+				t.Errorf("strict: processResources() error = %v, want forbidden", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"config"}; !reflect.DeepEqual(recorder.names, want) {
+			t.Errorf("processed = %v, want %v", recorder.names, want)
+		}
+		want := []DumpWarning{{GroupVersion: "v1", Resource: "secrets", Namespace: "demo", Reason: "secrets is forbidden"}}
+		if got := opt.warnings.list(); !reflect.DeepEqual(got, want) {
+			t.Errorf("warnings = %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_discoverResources_requiredResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		required []string
+		want     []string
+	}{
+		{
+			name:     "required only",
+			required: []string{"core/ConfigMap", "apps/Deployment"},
+			want:     []string{"configmaps"},
+		},
+		{
+			name:     "include and required",
+			include:  []string{"secrets"},
+			required: []string{"core/ConfigMap", "core/Secret"},
+			want:     []string{"secrets"},
+		},
+		{
+			name:     "exclude and required",
+			exclude:  []string{"configmaps"},
+			required: []string{"core/ConfigMap"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &resourceProcessor{
+				disc:              partialDiscovery{&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}},
+				di:                newNamespaceServer(t, nil, new(int)),
+				includeResources:  tt.include,
+				excludeResources:  tt.exclude,
+				requiredResources: tt.required,
+			}
+			tasks, err := opt.discoverResources()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resources []string
+			for _, task := range tasks {
+				resources = append(resources, task.gvr.Resource)
+			}
+			if !reflect.DeepEqual(resources, tt.want) {
+				t.Errorf("resources = %v, want %v", resources, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processResources_concurrency(t *testing.T) {
+	// the ConfigMaps of ns-3 and ns-7 can't be listed, ns-3 fails after ns-7 has failed
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns := strings.Split(r.URL.Path, "/")[4]
+		w.Header().Set("Content-Type", "application/json")
+		switch ns {
+		case "ns-3":
+			time.Sleep(50 * time.Millisecond)
+			fallthrough
+		case "ns-7":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"%s failed","reason":"InternalError","code":500}`, ns)
+			return
+		}
+		time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+		var items []string
+		for i := range 3 {
+			items = append(items, fmt.Sprintf(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm-%d","namespace":"%s"}}`, i, ns))
+		}
+		_, _ = fmt.Fprintf(w, `{"kind":"ConfigMapList","apiVersion":"v1","metadata":{},"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer server.Close()
+	di, err := dynamic.NewForConfig(&rest.Config{Host: server.URL, QPS: 1e6, Burst: 1e6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tasks []resourceTask
+	for i := range 10 {
+		tasks = append(tasks, resourceTask{gvr: configMapGVR, kind: "ConfigMap", namespace: fmt.Sprintf("ns-%d", i)})
+	}
+
+	process := func(strict bool) (map[string][]byte, []DumpWarning, error) {
+		w := &memoryWriter{files: map[string][]byte{}}
+		stats := newBackupStats()
+		opt := &resourceProcessor{
+			di:            di,
+			itemProcessor: itemDumper{storage: w, dataDir: "/data", stats: stats},
+			concurrency:   4,
+			strict:        strict,
+			stats:         stats,
+		}
+		err := opt.processResources(tasks)
+		return w.files, opt.warnings.list(), err
+	}
+
+	wantFiles, wantWarnings, err := process(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wantFiles) != 24 || len(wantWarnings) != 2 {
+		t.Fatalf("dumped %d files with warnings %v, want 24 files and a warning for ns-3 and ns-7", len(wantFiles), wantWarnings)
+	}
+	for range 5 {
+		files, warnings, err := process(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, wantFiles) || !reflect.DeepEqual(warnings, wantWarnings) {
+			t.Fatalf("the output differs between the runs: %d files with warnings %v", len(files), warnings)
+		}
+
+		if _, _, err := process(true); err == nil || !strings.Contains(err.Error(), "ns-3 failed") {
+			t.Fatalf("strict: processResources() error = %v, want the error of ns-3", err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"stash.appscode.dev/kubedump/pkg/sanitizers"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+type RestoreManager interface {
+	Restore() (*RestoreReport, error)
+}
+
+type RestoreOptions struct {
+	Config  *rest.Config
+	DataDir string
+	// NamespaceMapping maps the namespaces of the dumped objects to the namespaces they will be restored into.
+	NamespaceMapping map[string]string
+	ConflictPolicy   ConflictPolicy
+	// DryRun submits the objects with server-side dry-run and reports the changes instead of writing them.
+	DryRun bool
+	Filter RestoreFilter
+	// EncryptionKey decrypts the data of the encrypted Secrets
+	EncryptionKey []byte
+}
+
+func NewRestoreManager(opt RestoreOptions) RestoreManager {
+	mgr := &resourceRestoreManager{
+		config:           opt.Config,
+		dataDir:          opt.DataDir,
+		namespaceMapping: opt.NamespaceMapping,
+		conflictPolicy:   opt.ConflictPolicy,
+		dryRun:           opt.DryRun,
+		filter:           opt.Filter,
+		encryptionKey:    opt.EncryptionKey,
+		namespaces:       make(map[string]bool),
+		uids:             make(map[objectID]types.UID),
+	}
+	if mgr.conflictPolicy == "" {
+		mgr.conflictPolicy = ConflictPolicySkip
+	}
+	return mgr
+}
+
+type resourceRestoreManager struct {
+	config           *rest.Config
+	dataDir          string
+	namespaceMapping namespaceMapper
+	conflictPolicy   ConflictPolicy
+	dryRun           bool
+	filter           RestoreFilter
+	encryptionKey    []byte
+	di               dynamic.Interface
+	mapper           meta.RESTMapper
+	// namespaces caches whether a namespace exists in the cluster. It is only used on dry-run.
+	namespaces map[string]bool
+	// uids holds the UIDs of the restored objects. They are used to rebuild the owner references of their dependants.
+	uids map[objectID]types.UID
+}
+
+// restoreItem is an object read back from the dumped YAML tree along with the file it was read from.
+type restoreItem struct {
+	path string
+	obj  *unstructured.Unstructured
+}
+
+func (opt *resourceRestoreManager) Restore() (*RestoreReport, error) {
+	switch opt.conflictPolicy {
+	case ConflictPolicySkip, ConflictPolicyOverwrite, ConflictPolicyFail, ConflictPolicyMerge:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy: %s", opt.conflictPolicy)
+	}
+
+	filter, err := newRestoreFilter(opt.filter)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := newSecretEncryptor(opt.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = opt.configure()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := loadItems(opt.dataDir, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if err := encryptor.decrypt(item.obj); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", item.path, err)
+		}
+		if err := sanitizeItem(item); err != nil {
+			return nil, fmt.Errorf("failed to sanitize %s: %w", item.path, err)
+		}
+		if err := opt.namespaceMapping.remap(item.obj); err != nil {
+			return nil, fmt.Errorf("failed to remap namespace of %s: %w", item.path, err)
+		}
+	}
+	if !opt.dryRun {
+		if err := opt.ensureNamespaces(items); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &RestoreReport{
+		ConflictPolicy: opt.conflictPolicy,
+		DryRun:         opt.dryRun,
+	}
+	var errs []error
+	for _, wave := range planRestore(items) {
+		if err := opt.restoreWave(wave, report); err != nil {
+			errs = append(errs, err)
+		}
+		w := report.Waves[len(report.Waves)-1]
+		klog.Infof("Restore wave %q: %d restored, %d skipped, %d failed", w.Name, w.Restored, w.Skipped, w.Failed)
+	}
+	return report, errors.NewAggregate(errs)
+}
+
+func (opt *resourceRestoreManager) restoreWave(wave restoreWave, report *RestoreReport) error {
+	var (
+		result = WaveReport{Name: wave.name}
+		errs   []error
+		crds   []string
+	)
+	for _, item := range wave.items {
+		var (
+			status ObjectRestoreStatus
+			err    error
+		)
+		if opt.dryRun {
+			status, err = opt.previewItem(item)
+		} else {
+			var action RestoreAction
+			action, err = opt.applyItem(item)
+			status = newObjectRestoreStatus(item, action, err)
+		}
+		result.record(status.Action)
+		report.Objects = append(report.Objects, status)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", item.path, err))
+			continue
+		}
+		if isCRD(item.obj) {
+			crds = append(crds, item.obj.GetName())
+		}
+	}
+	report.Waves = append(report.Waves, result)
+
+	// the instances of the restored CRDs can only be created once the API server serves them.
+	if len(crds) > 0 && !opt.dryRun {
+		if err := opt.waitForCRDsEstablished(crds); err != nil {
+			errs = append(errs, fmt.Errorf("failed to wait for CRDs to be established: %w", err))
+		}
+		if err := opt.configure(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.NewAggregate(errs)
+}
+
+func (opt *resourceRestoreManager) configure() error {
+	disc, err := discovery.NewDiscoveryClientForConfig(opt.config)
+	if err != nil {
+		return err
+	}
+	apiResources, err := restmapper.GetAPIGroupResources(disc)
+	if err != nil {
+		return err
+	}
+	opt.mapper = restmapper.NewDiscoveryRESTMapper(apiResources)
+
+	opt.di, err = dynamic.NewForConfig(opt.config)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// applyItem creates the object in the cluster. If the object already exists, the conflict policy decides what happens to it.
+func (opt *resourceRestoreManager) applyItem(item restoreItem) (RestoreAction, error) {
+	ri, err := opt.resourceInterface(item.obj)
+	if err != nil {
+		return RestoreActionFailed, err
+	}
+	if err := opt.rebuildOwnerReferences(item.obj); err != nil {
+		return RestoreActionFailed, err
+	}
+
+	action, live, err := opt.writeItem(ri, item)
+	if live != nil {
+		opt.uids[objectIDOf(item.obj)] = live.GetUID()
+	}
+	return action, err
+}
+
+// writeItem writes the object according to the conflict policy and returns the live object if there is one.
+func (opt *resourceRestoreManager) writeItem(ri dynamic.ResourceInterface, item restoreItem) (RestoreAction, *unstructured.Unstructured, error) {
+	created, err := ri.Create(context.TODO(), item.obj, metav1.CreateOptions{FieldManager: FieldManager})
+	if err == nil {
+		klog.V(5).Infoln("Restored", objectKey(item.obj))
+		return RestoreActionCreated, created, nil
+	}
+	if !kerr.IsAlreadyExists(err) {
+		return RestoreActionFailed, nil, err
+	}
+
+	live, err := ri.Get(context.TODO(), item.obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return RestoreActionFailed, nil, err
+	}
+
+	switch opt.conflictPolicy {
+	case ConflictPolicyOverwrite:
+		obj := item.obj.DeepCopy()
+		obj.SetResourceVersion(live.GetResourceVersion())
+		updated, err := ri.Update(context.TODO(), obj, metav1.UpdateOptions{FieldManager: FieldManager})
+		if err != nil {
+			return RestoreActionFailed, live, err
+		}
+		klog.Infoln("Overwritten", objectKey(item.obj))
+		return RestoreActionOverwritten, updated, nil
+	case ConflictPolicyMerge:
+		applied, err := ri.Apply(context.TODO(), item.obj.GetName(), item.obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return RestoreActionFailed, live, err
+		}
+		klog.Infoln("Merged", objectKey(item.obj))
+		return RestoreActionMerged, applied, nil
+	case ConflictPolicyFail:
+		return RestoreActionFailed, live, fmt.Errorf("%s already exists", objectKey(item.obj))
+	default:
+		klog.Infof("Skipping %s as it already exists", objectKey(item.obj))
+		return RestoreActionSkipped, live, nil
+	}
+}
+
+func (opt *resourceRestoreManager) resourceInterface(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := opt.mapper.RESTMapping(schema.GroupKind{Group: gvk.Group, Kind: gvk.Kind}, gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return opt.di.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+	}
+	return opt.di.Resource(mapping.Resource), nil
+}
+
+// loadItems reads the dumped objects selected by the filter.
+func loadItems(dataDir string, filter *restoreFilter) ([]restoreItem, error) {
+	var items []restoreItem
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return nil
+		}
+		obj, err := readItem(path)
+		if err != nil {
+			return err
+		}
+		if !filter.matches(obj) {
+			return nil
+		}
+		items = append(items, restoreItem{path: path, obj: obj})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func readItem(path string) (*unstructured.Unstructured, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	err = yaml.Unmarshal(data, &obj.Object)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return obj, nil
+}
+
+// sanitizeItem strips the fields populated by the API server so that the object can be created again.
+// The Secrets have been filtered on backup, so all of them are kept.
+func sanitizeItem(item restoreItem) error {
+	data, err := sanitizers.NewSanitizer(item.obj.GetKind(), sanitizers.SecretPolicy{}).Sanitize(item.obj.Object)
+	if err != nil {
+		return err
+	}
+	delete(data, "status")
+	item.obj.Object = data
+	return nil
+}
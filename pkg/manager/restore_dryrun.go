@@ -0,0 +1,189 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gomodules.xyz/sets"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ignoredDiffFields are the fields that the API server changes on every write. They are not reported in the diff.
+var ignoredDiffFields = sets.NewString(
+	"metadata.managedFields",
+	"metadata.resourceVersion",
+	"metadata.generation",
+	"status",
+)
+
+// previewItem submits the object to the API server with server-side dry-run and compares the result with the live object.
+// Nothing is persisted, but admission webhooks and schema validation still run. The diff with an existing object is
+// computed whatever the conflict policy is, so that a skipped object that differs from the backup can be spotted.
+func (opt *resourceRestoreManager) previewItem(item restoreItem) (ObjectRestoreStatus, error) {
+	if ns := item.obj.GetNamespace(); ns != "" && !opt.namespaceExists(ns) {
+		status := newObjectRestoreStatus(item, RestoreActionCreated, nil)
+		status.Message = fmt.Sprintf("not validated by the server as namespace %s does not exist yet", ns)
+		return status, nil
+	}
+
+	ri, err := opt.resourceInterface(item.obj)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			status := newObjectRestoreStatus(item, RestoreActionCreated, nil)
+			status.Message = "not validated by the server as its resource type is not served yet"
+			return status, nil
+		}
+		return newObjectRestoreStatus(item, RestoreActionFailed, err), err
+	}
+	// the owners are previewed before their dependants, so the references are rebuilt as on restore
+	if err := opt.rebuildOwnerReferences(item.obj); err != nil {
+		return newObjectRestoreStatus(item, RestoreActionFailed, err), err
+	}
+
+	dryRun := []string{metav1.DryRunAll}
+	live, err := ri.Get(context.TODO(), item.obj.GetName(), metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		created, err := ri.Create(context.TODO(), item.obj, metav1.CreateOptions{DryRun: dryRun, FieldManager: FieldManager})
+		if err != nil {
+			return newObjectRestoreStatus(item, RestoreActionFailed, err), err
+		}
+		// the dependants refer to the UID the owner would be created with
+		opt.uids[objectIDOf(item.obj)] = created.GetUID()
+		return newObjectRestoreStatus(item, RestoreActionCreated, nil), nil
+	}
+	if err != nil {
+		return newObjectRestoreStatus(item, RestoreActionFailed, err), err
+	}
+	opt.uids[objectIDOf(item.obj)] = live.GetUID()
+
+	var (
+		result *unstructured.Unstructured
+		action RestoreAction
+	)
+	if opt.conflictPolicy == ConflictPolicyMerge {
+		result, err = ri.Apply(context.TODO(), item.obj.GetName(), item.obj, metav1.ApplyOptions{DryRun: dryRun, FieldManager: FieldManager, Force: true})
+		action = RestoreActionMerged
+	} else {
+		// the object as it would be if it was overwritten
+		obj := item.obj.DeepCopy()
+		obj.SetResourceVersion(live.GetResourceVersion())
+		result, err = ri.Update(context.TODO(), obj, metav1.UpdateOptions{DryRun: dryRun, FieldManager: FieldManager})
+		action = RestoreActionOverwritten
+	}
+	if err != nil {
+		if opt.conflictPolicy == ConflictPolicySkip {
+			// the object is not written, so it does not matter whether it could be
+			status := newObjectRestoreStatus(item, RestoreActionSkipped, nil)
+			status.Message = fmt.Sprintf("not compared with the live object: %v", err)
+			return status, nil
+		}
+		return newObjectRestoreStatus(item, RestoreActionFailed, err), err
+	}
+	changes := diffFields(live.Object, result.Object, "")
+
+	switch opt.conflictPolicy {
+	case ConflictPolicyFail:
+		err = fmt.Errorf("%s already exists", objectKey(item.obj))
+		status := newObjectRestoreStatus(item, RestoreActionFailed, err)
+		status.Changes = changes
+		return status, err
+	case ConflictPolicySkip:
+		action = RestoreActionSkipped
+	}
+	status := newObjectRestoreStatus(item, action, nil)
+	status.Changes = changes
+	if len(status.Changes) == 0 {
+		status.Action = RestoreActionUnchanged
+	}
+	return status, nil
+}
+
+func (opt *resourceRestoreManager) namespaceExists(ns string) bool {
+	if exists, ok := opt.namespaces[ns]; ok {
+		return exists
+	}
+	_, err := opt.di.Resource(namespaceGVR).Get(context.TODO(), ns, metav1.GetOptions{})
+	opt.namespaces[ns] = err == nil
+	return err == nil
+}
+
+// diffFields returns the sorted paths of the fields that differ between the live and the desired object.
+func diffFields(live, desired map[string]any, prefix string) []string {
+	var changes []string
+	keys := sets.StringKeySet(live).Union(sets.StringKeySet(desired))
+	for _, k := range keys.List() {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if ignoredDiffFields.Has(path) {
+			continue
+		}
+
+		l, lok := live[k]
+		d, dok := desired[k]
+		lm, lIsMap := l.(map[string]any)
+		dm, dIsMap := d.(map[string]any)
+		switch {
+		case lok && dok && lIsMap && dIsMap:
+			changes = append(changes, diffFields(lm, dm, path)...)
+		case !reflect.DeepEqual(l, d):
+			changes = append(changes, path)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// FormatPreview renders the dry-run result of every object in a human readable form.
+func (r *RestoreReport) FormatPreview() string {
+	var sb strings.Builder
+	for _, o := range r.Objects {
+		key := o.Kind + " " + o.Name
+		if o.Namespace != "" {
+			key = o.Kind + " " + o.Namespace + "/" + o.Name
+		}
+
+		switch o.Action {
+		case RestoreActionCreated:
+			fmt.Fprintf(&sb, "+ %s (created)", key)
+		case RestoreActionUnchanged, RestoreActionSkipped:
+			fmt.Fprintf(&sb, "= %s (%s)", key, strings.ToLower(string(o.Action)))
+		case RestoreActionFailed:
+			fmt.Fprintf(&sb, "! %s (failed: %s)", key, o.Error)
+		default:
+			fmt.Fprintf(&sb, "~ %s (%s)", key, strings.ToLower(string(o.Action)))
+		}
+		if o.Message != "" {
+			fmt.Fprintf(&sb, " [%s]", o.Message)
+		}
+		// the fields of a skipped or failed object are the ones that differ from the backup
+		for _, c := range o.Changes {
+			fmt.Fprintf(&sb, "\n    %s", c)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_diffFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    map[string]any
+		desired map[string]any
+		want    []string
+	}{
+		{
+			name: "unchanged",
+			live: map[string]any{
+				"metadata": map[string]any{"name": "web", "resourceVersion": "1"},
+				"spec":     map[string]any{"replicas": int64(1)},
+			},
+			desired: map[string]any{
+				"metadata": map[string]any{"name": "web", "resourceVersion": "2"},
+				"spec":     map[string]any{"replicas": int64(1)},
+			},
+		},
+		{
+			name: "changed, added and removed fields",
+			live: map[string]any{
+				"metadata": map[string]any{"name": "web", "labels": map[string]any{"app": "web"}},
+				"spec":     map[string]any{"replicas": int64(1), "paused": true},
+			},
+			desired: map[string]any{
+				"metadata": map[string]any{"name": "web", "labels": map[string]any{"app": "web", "tier": "frontend"}},
+				"spec":     map[string]any{"replicas": int64(3)},
+			},
+			want: []string{"metadata.labels.tier", "spec.paused", "spec.replicas"},
+		},
+		{
+			name:    "lists are compared as a whole",
+			live:    map[string]any{"spec": map[string]any{"ports": []any{int64(80)}}},
+			desired: map[string]any{"spec": map[string]any{"ports": []any{int64(80), int64(443)}}},
+			want:    []string{"spec.ports"},
+		},
+		{
+			name:    "ignored fields",
+			live:    map[string]any{"metadata": map[string]any{"managedFields": []any{"a"}, "generation": int64(1)}, "status": map[string]any{"ready": true}},
+			desired: map[string]any{"metadata": map[string]any{"managedFields": []any{"b"}, "generation": int64(2)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffFields(tt.live, tt.desired, ""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FormatPreview(t *testing.T) {
+	report := &RestoreReport{
+		DryRun: true,
+		Objects: []ObjectRestoreStatus{
+			{Kind: "Namespace", Name: "demo", Action: RestoreActionCreated},
+			{Kind: "ConfigMap", Namespace: "demo", Name: "same", Action: RestoreActionUnchanged},
+			{Kind: "ConfigMap", Namespace: "demo", Name: "differs", Action: RestoreActionSkipped, Changes: []string{"data.key"}},
+			{Kind: "Deployment", Namespace: "demo", Name: "web", Action: RestoreActionOverwritten, Changes: []string{"spec.replicas"}},
+			{Kind: "Service", Namespace: "demo", Name: "web", Action: RestoreActionFailed, Error: "denied by webhook"},
+			{Kind: "Widget", Namespace: "new", Name: "w", Action: RestoreActionCreated, Message: "not validated by the server"},
+		},
+	}
+	want := `+ Namespace demo (created)
+= ConfigMap demo/same (unchanged)
+= ConfigMap demo/differs (skipped)
+    data.key
+~ Deployment demo/web (overwritten)
+    spec.replicas
+! Service demo/web (failed: denied by webhook)
++ Widget new/w (created) [not validated by the server]
+`
+	if got := report.FormatPreview(); got != want {
+		t.Errorf("FormatPreview() =\n%s\nwant\n%s", got, want)
+	}
+}
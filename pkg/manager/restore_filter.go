@@ -0,0 +1,148 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"path"
+	"strings"
+
+	"gomodules.xyz/sets"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RestoreFilter selects the dumped objects that will be restored. An empty filter selects every object.
+type RestoreFilter struct {
+	// IncludeKinds are the kinds to restore. A kind can be qualified with its group (i.e. Deployment.apps).
+	IncludeKinds []string
+	// ExcludeKinds are the kinds to skip. A kind can be qualified with its group (i.e. Deployment.apps).
+	ExcludeKinds []string
+	// Names are glob patterns matched against the object names.
+	Names []string
+	// Selector is a label selector evaluated against the dumped labels of the objects.
+	Selector string
+	// Namespaces are the namespaces of the dumped objects to restore. Cluster scoped objects are skipped
+	// except the selected Namespace objects themselves.
+	Namespaces []string
+}
+
+func (f RestoreFilter) isEmpty() bool {
+	return len(f.IncludeKinds) == 0 && len(f.ExcludeKinds) == 0 && len(f.Names) == 0 && f.Selector == "" && len(f.Namespaces) == 0
+}
+
+// IncludePatterns returns the restic include patterns that fetch only the files that may contain the selected
+// objects. It returns nothing when the filter can't be expressed by the file layout and everything must be fetched.
+//
+// Every object is stored in a file named after it, but where depends on the dump: the generic dumps store the
+// objects as <Kind>/<name>.yaml, the application dumps store the root as <name>.yaml and the dependants as
+// <Kind>/<name>/<name>.yaml. So the files are only selected by the names, and the kinds, which are matched
+// case-insensitively, are filtered once the files have been restored.
+func (f RestoreFilter) IncludePatterns() []string {
+	var patterns []string
+	for _, n := range f.Names {
+		patterns = append(patterns, path.Join("**", n+".yaml"))
+	}
+	return patterns
+}
+
+type restoreFilter struct {
+	includeKinds []schema.GroupKind
+	excludeKinds []schema.GroupKind
+	names        []string
+	selector     labels.Selector
+	namespaces   sets.String
+}
+
+func newRestoreFilter(f RestoreFilter) (*restoreFilter, error) {
+	if f.isEmpty() {
+		return nil, nil
+	}
+	for _, n := range f.Names {
+		if _, err := path.Match(n, ""); err != nil {
+			return nil, err
+		}
+	}
+	selector, err := labels.Parse(f.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return &restoreFilter{
+		includeKinds: parseGroupKinds(f.IncludeKinds),
+		excludeKinds: parseGroupKinds(f.ExcludeKinds),
+		names:        f.Names,
+		selector:     selector,
+		namespaces:   sets.NewString(f.Namespaces...),
+	}, nil
+}
+
+func parseGroupKinds(in []string) []schema.GroupKind {
+	out := make([]schema.GroupKind, 0, len(in))
+	for _, k := range in {
+		out = append(out, schema.ParseGroupKind(k))
+	}
+	return out
+}
+
+// matches reports whether the object is selected. A nil filter selects every object.
+func (f *restoreFilter) matches(obj *unstructured.Unstructured) bool {
+	if f == nil {
+		return true
+	}
+	gk := obj.GroupVersionKind().GroupKind()
+	if len(f.includeKinds) > 0 && !matchesAnyKind(gk, f.includeKinds) {
+		return false
+	}
+	if matchesAnyKind(gk, f.excludeKinds) {
+		return false
+	}
+	if len(f.names) > 0 && !matchesAnyName(obj.GetName(), f.names) {
+		return false
+	}
+	if !f.selector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	if f.namespaces.Len() > 0 {
+		ns := obj.GetNamespace()
+		if gk == namespaceGroupKind {
+			ns = obj.GetName()
+		}
+		if !f.namespaces.Has(ns) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAnyKind matches the kind case-insensitively. The group is only compared if the pattern specifies one.
+func matchesAnyKind(gk schema.GroupKind, patterns []schema.GroupKind) bool {
+	for _, p := range patterns {
+		if strings.EqualFold(p.Kind, gk.Kind) && (p.Group == "" || p.Group == gk.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyName(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_RestoreFilter_IncludePatterns(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter RestoreFilter
+		want   []string
+	}{
+		{name: "empty", filter: RestoreFilter{}},
+		{name: "kinds only", filter: RestoreFilter{IncludeKinds: []string{"deployment"}}},
+		{name: "names", filter: RestoreFilter{IncludeKinds: []string{"deployment"}, Names: []string{"web", "db-*"}}, want: []string{"**/web.yaml", "**/db-*.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.IncludePatterns(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IncludePatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func Test_restoreFilter_matches(t *testing.T) {
+	newObject := func(apiVersion, kind, namespace, name string, labels map[string]string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetLabels(labels)
+		return obj
+	}
+	deployment := newObject("apps/v1", "Deployment", "demo", "web", map[string]string{"app": "web"})
+	tests := []struct {
+		name   string
+		filter RestoreFilter
+		obj    *unstructured.Unstructured
+		want   bool
+	}{
+		{name: "lower case kind", filter: RestoreFilter{IncludeKinds: []string{"deployment"}}, obj: deployment, want: true},
+		{name: "qualified kind", filter: RestoreFilter{IncludeKinds: []string{"Deployment.apps"}}, obj: deployment, want: true},
+		{name: "kind of another group", filter: RestoreFilter{IncludeKinds: []string{"Deployment.example.com"}}, obj: deployment},
+		{name: "excluded kind", filter: RestoreFilter{ExcludeKinds: []string{"DEPLOYMENT"}}, obj: deployment},
+		{name: "name pattern", filter: RestoreFilter{Names: []string{"w*"}}, obj: deployment, want: true},
+		{name: "other name", filter: RestoreFilter{Names: []string{"db"}}, obj: deployment},
+		{name: "selector", filter: RestoreFilter{Selector: "app=web"}, obj: deployment, want: true},
+		{name: "other selector", filter: RestoreFilter{Selector: "app=db"}, obj: deployment},
+		{name: "namespace", filter: RestoreFilter{Namespaces: []string{"demo"}}, obj: deployment, want: true},
+		{name: "namespace object", filter: RestoreFilter{Namespaces: []string{"demo"}}, obj: newObject("v1", "Namespace", "", "demo", nil), want: true},
+		{name: "cluster scoped object", filter: RestoreFilter{Namespaces: []string{"demo"}}, obj: newObject("v1", "PersistentVolume", "", "pv", nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newRestoreFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.matches(tt.obj); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if f, _ := newRestoreFilter(RestoreFilter{}); !f.matches(deployment) {
+		t.Error("the empty filter does not select every object")
+	}
+	if _, err := newRestoreFilter(RestoreFilter{Names: []string{"["}}); err == nil {
+		t.Error("expected an error for an invalid name pattern")
+	}
+	if _, err := newRestoreFilter(RestoreFilter{Selector: "app in"}); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"strings"
+
+	"gomodules.xyz/sets"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+var (
+	namespaceGVR       = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	namespaceGroupKind = schema.GroupKind{Kind: "Namespace"}
+)
+
+// namespaceMapper maps the namespaces of the dumped objects to the namespaces they are restored into.
+type namespaceMapper map[string]string
+
+func (m namespaceMapper) target(ns string) string {
+	if to, ok := m[ns]; ok && to != "" {
+		return to
+	}
+	return ns
+}
+
+// remap rewrites the namespace of the object along with the namespace references inside it.
+func (m namespaceMapper) remap(obj *unstructured.Unstructured) error {
+	if len(m) == 0 {
+		return nil
+	}
+	if ns := obj.GetNamespace(); ns != "" {
+		obj.SetNamespace(m.target(ns))
+	}
+
+	switch obj.GroupVersionKind().GroupKind() {
+	case namespaceGroupKind:
+		obj.SetName(m.target(obj.GetName()))
+	case schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"},
+		schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:
+		return m.remapSliceField(obj.Object, []string{"subjects"}, "namespace")
+	case schema.GroupKind{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"},
+		schema.GroupKind{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}:
+		return m.remapSliceField(obj.Object, []string{"webhooks"}, "clientConfig", "service", "namespace")
+	case crdGroupKind:
+		return m.remapField(obj.Object, "spec", "conversion", "webhook", "clientConfig", "service", "namespace")
+	case schema.GroupKind{Group: "apiregistration.k8s.io", Kind: "APIService"}:
+		return m.remapField(obj.Object, "spec", "service", "namespace")
+	case schema.GroupKind{Group: "networking.k8s.io", Kind: "Ingress"},
+		schema.GroupKind{Group: "extensions", Kind: "Ingress"}:
+		if spec, ok := obj.Object["spec"]; ok {
+			obj.Object["spec"] = m.remapServiceFQDNs(spec)
+		}
+	case schema.GroupKind{Kind: "Service"}:
+		return m.remapFQDNField(obj.Object, "spec", "externalName")
+	}
+	return nil
+}
+
+func (m namespaceMapper) remapField(obj map[string]any, fields ...string) error {
+	ns, found, err := unstructured.NestedString(obj, fields...)
+	if err != nil || !found || ns == "" {
+		return err
+	}
+	return unstructured.SetNestedField(obj, m.target(ns), fields...)
+}
+
+func (m namespaceMapper) remapFQDNField(obj map[string]any, fields ...string) error {
+	name, found, err := unstructured.NestedString(obj, fields...)
+	if err != nil || !found {
+		return err
+	}
+	return unstructured.SetNestedField(obj, m.remapServiceFQDN(name), fields...)
+}
+
+// remapSliceField rewrites the namespace found at the given path of every element of a slice field.
+func (m namespaceMapper) remapSliceField(obj map[string]any, sliceFields []string, fields ...string) error {
+	elems, found, err := unstructured.NestedSlice(obj, sliceFields...)
+	if err != nil || !found {
+		return err
+	}
+	for i := range elems {
+		elem, ok := elems[i].(map[string]any)
+		if !ok {
+			continue
+		}
+		if err := m.remapField(elem, fields...); err != nil {
+			return err
+		}
+	}
+	return unstructured.SetNestedSlice(obj, elems, sliceFields...)
+}
+
+// remapServiceFQDNs rewrites every in-cluster Service FQDN (i.e. <service>.<namespace>.svc[.<cluster-domain>]) found in the value.
+func (m namespaceMapper) remapServiceFQDNs(in any) any {
+	switch v := in.(type) {
+	case map[string]any:
+		for k := range v {
+			v[k] = m.remapServiceFQDNs(v[k])
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = m.remapServiceFQDNs(v[i])
+		}
+		return v
+	case string:
+		return m.remapServiceFQDN(v)
+	default:
+		return in
+	}
+}
+
+func (m namespaceMapper) remapServiceFQDN(name string) string {
+	parts := strings.Split(name, ".")
+	for i := 1; i < len(parts)-1; i++ {
+		if parts[i+1] == "svc" {
+			parts[i] = m.target(parts[i])
+			return strings.Join(parts, ".")
+		}
+	}
+	return name
+}
+
+// ensureNamespaces creates the namespaces the objects are restored into, unless they exist
+// already or the dump carries the Namespace object itself.
+func (opt *resourceRestoreManager) ensureNamespaces(items []restoreItem) error {
+	targets := sets.NewString()
+	for _, to := range opt.namespaceMapping {
+		targets.Insert(to)
+	}
+
+	required := sets.NewString()
+	dumped := sets.NewString()
+	for _, item := range items {
+		if item.obj.GroupVersionKind().GroupKind() == namespaceGroupKind {
+			dumped.Insert(item.obj.GetName())
+		}
+		if ns := item.obj.GetNamespace(); targets.Has(ns) {
+			required.Insert(ns)
+		}
+	}
+
+	for _, ns := range required.Difference(dumped).List() {
+		_, err := opt.di.Resource(namespaceGVR).Get(context.TODO(), ns, metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !kerr.IsNotFound(err) {
+			return err
+		}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Namespace")
+		obj.SetName(ns)
+		_, err = opt.di.Resource(namespaceGVR).Create(context.TODO(), obj, metav1.CreateOptions{})
+		if err != nil && !kerr.IsAlreadyExists(err) {
+			return err
+		}
+		klog.Infoln("Created namespace:", ns)
+	}
+	return nil
+}
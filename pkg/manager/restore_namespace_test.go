@@ -0,0 +1,115 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_namespaceMapper_remap(t *testing.T) {
+	m := namespaceMapper{"prod": "staging"}
+
+	tests := []struct {
+		name   string
+		obj    map[string]any
+		fields []string
+		want   string
+	}{
+		{
+			name: "object namespace",
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"name": "config", "namespace": "prod"},
+			},
+			fields: []string{"metadata", "namespace"},
+			want:   "staging",
+		},
+		{
+			name: "namespace name",
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata":   map[string]any{"name": "prod"},
+			},
+			fields: []string{"metadata", "name"},
+			want:   "staging",
+		},
+		{
+			name: "unmapped namespace",
+			obj: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"name": "config", "namespace": "dev"},
+			},
+			fields: []string{"metadata", "namespace"},
+			want:   "dev",
+		},
+		{
+			name: "service FQDN in ingress backend",
+			obj: map[string]any{
+				"apiVersion": "networking.k8s.io/v1",
+				"kind":       "Ingress",
+				"metadata":   map[string]any{"name": "web", "namespace": "prod"},
+				"spec": map[string]any{
+					"defaultBackend": map[string]any{
+						"service": map[string]any{"name": "web.prod.svc.cluster.local"},
+					},
+				},
+			},
+			fields: []string{"spec", "defaultBackend", "service", "name"},
+			want:   "web.staging.svc.cluster.local",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: tt.obj}
+			if err := m.remap(obj); err != nil {
+				t.Fatalf("remap() error = %v", err)
+			}
+			got, _, _ := unstructured.NestedString(obj.Object, tt.fields...)
+			if got != tt.want {
+				t.Errorf("remap() %v = %q, want %q", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_namespaceMapper_remapSubjects(t *testing.T) {
+	m := namespaceMapper{"prod": "staging"}
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata":   map[string]any{"name": "binding", "namespace": "prod"},
+		"subjects": []any{
+			map[string]any{"kind": "ServiceAccount", "name": "app", "namespace": "prod"},
+			map[string]any{"kind": "ServiceAccount", "name": "monitor", "namespace": "monitoring"},
+		},
+	}}
+	if err := m.remap(obj); err != nil {
+		t.Fatalf("remap() error = %v", err)
+	}
+	subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+	want := []string{"staging", "monitoring"}
+	for i := range subjects {
+		if ns := subjects[i].(map[string]any)["namespace"]; ns != want[i] {
+			t.Errorf("remap() subjects[%d].namespace = %v, want %v", i, ns, want[i])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// objectID identifies an object independent of its UID, which changes when the object is restored.
+type objectID struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+func objectIDOf(obj *unstructured.Unstructured) objectID {
+	return objectID{
+		group:     obj.GroupVersionKind().Group,
+		kind:      obj.GetKind(),
+		namespace: obj.GetNamespace(),
+		name:      obj.GetName(),
+	}
+}
+
+// ownerIDs returns the possible identities of the owner. The owner may be either in the namespace of the dependant
+// or cluster scoped.
+func ownerIDs(ref metav1.OwnerReference, namespace string) []objectID {
+	gv, _ := schema.ParseGroupVersion(ref.APIVersion)
+	id := objectID{group: gv.Group, kind: ref.Kind, name: ref.Name}
+	if namespace == "" {
+		return []objectID{id}
+	}
+	namespaced := id
+	namespaced.namespace = namespace
+	return []objectID{namespaced, id}
+}
+
+// dumpedOwners returns the indexes of the dumped items that own the given item.
+func dumpedOwners(item restoreItem, index map[objectID]int) []int {
+	var owners []int
+	for _, ref := range item.obj.GetOwnerReferences() {
+		for _, id := range ownerIDs(ref, item.obj.GetNamespace()) {
+			if i, ok := index[id]; ok {
+				owners = append(owners, i)
+				break
+			}
+		}
+	}
+	return owners
+}
+
+// rebuildOwnerReferences points the owner references of the object to the UIDs of the restored owners.
+// References to owners that exist neither in the dump nor in the cluster are dropped, otherwise the
+// garbage collector would delete the object.
+func (opt *resourceRestoreManager) rebuildOwnerReferences(obj *unstructured.Unstructured) error {
+	refs := obj.GetOwnerReferences()
+	if len(refs) == 0 {
+		return nil
+	}
+
+	rebuilt := make([]metav1.OwnerReference, 0, len(refs))
+	for _, ref := range refs {
+		uid, err := opt.ownerUID(ref, obj.GetNamespace())
+		if err != nil {
+			return err
+		}
+		if uid == "" {
+			klog.Warningf("Dropping owner reference of %s to %s %s as the owner does not exist", objectKey(obj), ref.Kind, ref.Name)
+			continue
+		}
+		ref.UID = uid
+		rebuilt = append(rebuilt, ref)
+	}
+	obj.SetOwnerReferences(rebuilt)
+	return nil
+}
+
+// ownerUID returns the UID of the owner. It prefers the owners restored in this run and falls back to the live owner.
+func (opt *resourceRestoreManager) ownerUID(ref metav1.OwnerReference, namespace string) (types.UID, error) {
+	ids := ownerIDs(ref, namespace)
+	for _, id := range ids {
+		if uid, ok := opt.uids[id]; ok {
+			return uid, nil
+		}
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return "", err
+	}
+	mapping, err := opt.mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, gv.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	id := ids[len(ids)-1]
+	ri := opt.di.Resource(mapping.Resource)
+	var live *unstructured.Unstructured
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		id = ids[0]
+		live, err = ri.Namespace(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	} else {
+		live, err = ri.Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	opt.uids[id] = live.GetUID()
+	return live.GetUID(), nil
+}
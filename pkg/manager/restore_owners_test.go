@@ -0,0 +1,113 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_rebuildOwnerReferences(t *testing.T) {
+	var (
+		deploymentGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		nodeGVR       = schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	)
+	newObject := func(apiVersion, kind, namespace, name string, uid types.UID) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		obj.SetUID(uid)
+		return obj
+	}
+	ownerRef := func(apiVersion, kind, name string) metav1.OwnerReference {
+		return metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name, UID: "dumped-uid"}
+	}
+
+	tests := []struct {
+		name     string
+		restored map[objectID]types.UID
+		owners   []metav1.OwnerReference
+		want     []types.UID
+	}{
+		{
+			name:     "owner restored in this run",
+			restored: map[objectID]types.UID{{group: "apps", kind: "Deployment", namespace: "demo", name: "web"}: "restored-uid"},
+			owners:   []metav1.OwnerReference{ownerRef("apps/v1", "Deployment", "web")},
+			want:     []types.UID{"restored-uid"},
+		},
+		{
+			name:   "live owner",
+			owners: []metav1.OwnerReference{ownerRef("apps/v1", "Deployment", "live")},
+			want:   []types.UID{"live-uid"},
+		},
+		{
+			name:   "live cluster scoped owner",
+			owners: []metav1.OwnerReference{ownerRef("v1", "Node", "node-1")},
+			want:   []types.UID{"node-uid"},
+		},
+		{
+			name: "missing owners are dropped",
+			owners: []metav1.OwnerReference{
+				ownerRef("apps/v1", "Deployment", "missing"),
+				ownerRef("apps/v1", "Deployment", "live"),
+				ownerRef("example.com/v1", "Unknown", "web"),
+			},
+			want: []types.UID{"live-uid"},
+		},
+		{
+			name:   "owner in another namespace is missing",
+			owners: []metav1.OwnerReference{ownerRef("apps/v1", "Deployment", "other")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeDynamicClient(deploymentGVR,
+				newObject("apps/v1", "Deployment", "demo", "live", "live-uid"),
+				newObject("apps/v1", "Deployment", "prod", "other", "other-uid"),
+			)
+			client.seed(nodeGVR, newObject("v1", "Node", "", "node-1", "node-uid"))
+			opt := &resourceRestoreManager{
+				di:     client,
+				mapper: newTestRESTMapper(),
+				uids:   make(map[objectID]types.UID),
+			}
+			for id, uid := range tt.restored {
+				opt.uids[id] = uid
+			}
+
+			obj := newObject("apps/v1", "ReplicaSet", "demo", "web-1", "")
+			obj.SetOwnerReferences(tt.owners)
+			if err := opt.rebuildOwnerReferences(obj); err != nil {
+				t.Fatal(err)
+			}
+			var got []types.UID
+			for _, ref := range obj.GetOwnerReferences() {
+				got = append(got, ref.UID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("owner UIDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
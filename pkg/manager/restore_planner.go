@@ -0,0 +1,182 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	waveClusterDefinitions = "ClusterDefinitions"
+	waveFoundation         = "Foundation"
+	waveWorkloads          = "Workloads"
+	waveWebhooks           = "Webhooks"
+)
+
+// waveOrder lists the restore waves in the order they are applied.
+var waveOrder = []string{
+	waveClusterDefinitions,
+	waveFoundation,
+	waveWorkloads,
+	waveWebhooks,
+}
+
+// waveOfGroupKind assigns the well known kinds to their restore wave.
+// Any kind that is not listed here (including custom resources) is restored with the workloads.
+var waveOfGroupKind = map[schema.GroupKind]string{
+	crdGroupKind:                   waveClusterDefinitions,
+	{Group: "", Kind: "Namespace"}: waveClusterDefinitions,
+
+	{Group: "", Kind: "ServiceAccount"}:                              waveFoundation,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}:        waveFoundation,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}: waveFoundation,
+	{Group: "rbac.authorization.k8s.io", Kind: "Role"}:               waveFoundation,
+	{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}:        waveFoundation,
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                  waveFoundation,
+	{Group: "", Kind: "PersistentVolume"}:                            waveFoundation,
+	{Group: "", Kind: "PersistentVolumeClaim"}:                       waveFoundation,
+	{Group: "", Kind: "ConfigMap"}:                                   waveFoundation,
+	{Group: "", Kind: "Secret"}:                                      waveFoundation,
+	{Group: "", Kind: "LimitRange"}:                                  waveFoundation,
+	{Group: "", Kind: "ResourceQuota"}:                               waveFoundation,
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"}:              waveFoundation,
+	{Group: "networking.k8s.io", Kind: "IngressClass"}:               waveFoundation,
+
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:   waveWebhooks,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}: waveWebhooks,
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}:                           waveWebhooks,
+}
+
+var (
+	crdGVR       = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	crdGroupKind = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+)
+
+type restoreWave struct {
+	name  string
+	items []restoreItem
+}
+
+// planRestore sorts the dumped items into waves so that every object is created after the objects it depends on.
+// An object owned by another dumped object is restored in the same or a later wave than its owner and after
+// it inside the wave, so that the owner's new UID is known when the object is created.
+// Otherwise, items keep their relative order inside a wave.
+func planRestore(items []restoreItem) []restoreWave {
+	index := make(map[objectID]int, len(items))
+	for i := range items {
+		index[objectIDOf(items[i].obj)] = i
+	}
+
+	waveIdx := make([]int, len(items))
+	depth := make([]int, len(items))
+	state := make([]int, len(items)) // 0: unvisited, 1: visiting, 2: done
+	var resolve func(i int)
+	resolve = func(i int) {
+		if state[i] != 0 {
+			return
+		}
+		state[i] = 1
+		waveIdx[i] = waveIndex(waveOf(items[i].obj))
+		for _, o := range dumpedOwners(items[i], index) {
+			// ignore owner cycles, they can't be honored anyway
+			if state[o] == 1 {
+				continue
+			}
+			resolve(o)
+			waveIdx[i] = max(waveIdx[i], waveIdx[o])
+			depth[i] = max(depth[i], depth[o]+1)
+		}
+		state[i] = 2
+	}
+
+	byWave := make([][]int, len(waveOrder))
+	for i := range items {
+		resolve(i)
+		byWave[waveIdx[i]] = append(byWave[waveIdx[i]], i)
+	}
+
+	waves := make([]restoreWave, 0, len(waveOrder))
+	for w, members := range byWave {
+		if len(members) == 0 {
+			continue
+		}
+		sort.SliceStable(members, func(a, b int) bool {
+			return depth[members[a]] < depth[members[b]]
+		})
+		wave := restoreWave{name: waveOrder[w]}
+		for _, i := range members {
+			wave.items = append(wave.items, items[i])
+		}
+		waves = append(waves, wave)
+	}
+	return waves
+}
+
+func waveIndex(name string) int {
+	for i := range waveOrder {
+		if waveOrder[i] == name {
+			return i
+		}
+	}
+	return waveIndex(waveWorkloads)
+}
+
+func waveOf(obj *unstructured.Unstructured) string {
+	if w, ok := waveOfGroupKind[obj.GroupVersionKind().GroupKind()]; ok {
+		return w
+	}
+	return waveWorkloads
+}
+
+func isCRD(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == crdGroupKind
+}
+
+// waitForCRDsEstablished waits until every given CRD reports the Established condition so that
+// the API server is ready to serve their instances.
+func (opt *resourceRestoreManager) waitForCRDsEstablished(names []string) error {
+	for _, name := range names {
+		err := wait.PollUntilContextTimeout(context.TODO(), 2*time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+			crd, err := opt.di.Resource(crdGVR).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			conditions, _, err := unstructured.NestedSlice(crd.Object, "status", "conditions")
+			if err != nil {
+				return false, nil
+			}
+			for _, c := range conditions {
+				cond, ok := c.(map[string]any)
+				if ok && cond["type"] == "Established" && cond["status"] == string(metav1.ConditionTrue) {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestItem(apiVersion, kind, namespace, name string) restoreItem {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return restoreItem{path: kind + "/" + name + ".yaml", obj: obj}
+}
+
+func newTestOwnedItem(apiVersion, kind, namespace, name string, owner restoreItem) restoreItem {
+	item := newTestItem(apiVersion, kind, namespace, name)
+	item.obj.SetOwnerReferences([]metav1.OwnerReference{
+		{
+			APIVersion: owner.obj.GetAPIVersion(),
+			Kind:       owner.obj.GetKind(),
+			Name:       owner.obj.GetName(),
+			UID:        "old-uid",
+		},
+	})
+	return item
+}
+
+func Test_planRestore(t *testing.T) {
+	items := []restoreItem{
+		newTestItem("admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration", "", "validator"),
+		newTestItem("apps/v1", "Deployment", "demo", "app"),
+		newTestItem("example.com/v1", "Widget", "demo", "widget"),
+		newTestItem("v1", "ConfigMap", "demo", "config"),
+		newTestItem("v1", "ServiceAccount", "demo", "sa"),
+		newTestItem("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets.example.com"),
+		newTestItem("v1", "Namespace", "", "demo"),
+	}
+
+	got := make(map[string][]string)
+	var order []string
+	for _, w := range planRestore(items) {
+		order = append(order, w.name)
+		for _, item := range w.items {
+			got[w.name] = append(got[w.name], item.obj.GetName())
+		}
+	}
+
+	wantOrder := []string{waveClusterDefinitions, waveFoundation, waveWorkloads, waveWebhooks}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("planRestore() waves = %v, want %v", order, wantOrder)
+	}
+	want := map[string][]string{
+		waveClusterDefinitions: {"widgets.example.com", "demo"},
+		waveFoundation:         {"config", "sa"},
+		waveWorkloads:          {"app", "widget"},
+		waveWebhooks:           {"validator"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planRestore() = %v, want %v", got, want)
+	}
+}
+
+func Test_planRestore_owners(t *testing.T) {
+	deployment := newTestItem("apps/v1", "Deployment", "demo", "app")
+	replicaSet := newTestOwnedItem("apps/v1", "ReplicaSet", "demo", "app-5d8f", deployment)
+	pod := newTestOwnedItem("v1", "Pod", "demo", "app-5d8f-x2k", replicaSet)
+	widget := newTestItem("example.com/v1", "Widget", "demo", "widget")
+	secret := newTestOwnedItem("v1", "Secret", "demo", "widget-auth", widget)
+
+	var got []string
+	for _, w := range planRestore([]restoreItem{pod, secret, replicaSet, widget, deployment}) {
+		for _, item := range w.items {
+			got = append(got, w.name+"/"+item.obj.GetName())
+		}
+	}
+
+	want := []string{
+		waveWorkloads + "/widget",
+		waveWorkloads + "/app",
+		waveWorkloads + "/widget-auth",
+		waveWorkloads + "/app-5d8f",
+		waveWorkloads + "/app-5d8f-x2k",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planRestore() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const RestoreReportFileName = "restore-report.json"
+
+// ConflictPolicy specifies what happens when a restored object already exists in the cluster.
+type ConflictPolicy string
+
+const (
+	// ConflictPolicySkip keeps the live object untouched.
+	ConflictPolicySkip ConflictPolicy = "skip"
+	// ConflictPolicyOverwrite replaces the live object with the dumped one.
+	ConflictPolicyOverwrite ConflictPolicy = "overwrite"
+	// ConflictPolicyFail fails the restore of the object.
+	ConflictPolicyFail ConflictPolicy = "fail"
+	// ConflictPolicyMerge merges the dumped object into the live one using server-side apply.
+	ConflictPolicyMerge ConflictPolicy = "merge"
+)
+
+// FieldManager is the field manager kubedump uses for server-side apply.
+const FieldManager = "kubedump"
+
+// RestoreAction is the action that has been taken for a restored object.
+type RestoreAction string
+
+const (
+	RestoreActionCreated     RestoreAction = "Created"
+	RestoreActionSkipped     RestoreAction = "Skipped"
+	RestoreActionOverwritten RestoreAction = "Overwritten"
+	RestoreActionMerged      RestoreAction = "Merged"
+	RestoreActionFailed      RestoreAction = "Failed"
+	// RestoreActionUnchanged is only reported on dry-run when writing the object would not change the live object.
+	RestoreActionUnchanged RestoreAction = "Unchanged"
+)
+
+// RestoreReport records what the restore process did with every dumped object.
+type RestoreReport struct {
+	ConflictPolicy ConflictPolicy        `json:"conflictPolicy"`
+	DryRun         bool                  `json:"dryRun,omitempty"`
+	Waves          []WaveReport          `json:"waves,omitempty"`
+	Objects        []ObjectRestoreStatus `json:"objects,omitempty"`
+}
+
+// WaveReport shows the outcome of a single restore wave.
+type WaveReport struct {
+	Name     string `json:"name"`
+	Restored int    `json:"restored"`
+	Skipped  int    `json:"skipped"`
+	Failed   int    `json:"failed"`
+}
+
+// ObjectRestoreStatus shows the action that has been taken for a single object.
+type ObjectRestoreStatus struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Action     RestoreAction `json:"action"`
+	// Changes lists the paths of the fields of the live object that differ from the backup. It is only set on dry-run.
+	Changes []string `json:"changes,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func newObjectRestoreStatus(item restoreItem, action RestoreAction, err error) ObjectRestoreStatus {
+	status := ObjectRestoreStatus{
+		APIVersion: item.obj.GetAPIVersion(),
+		Kind:       item.obj.GetKind(),
+		Namespace:  item.obj.GetNamespace(),
+		Name:       item.obj.GetName(),
+		Path:       item.path,
+		Action:     action,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func (r *WaveReport) record(action RestoreAction) {
+	switch action {
+	case RestoreActionFailed:
+		r.Failed++
+	case RestoreActionSkipped, RestoreActionUnchanged:
+		r.Skipped++
+	default:
+		r.Restored++
+	}
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetKind() + " " + obj.GetName()
+	}
+	return obj.GetKind() + " " + obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,265 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// fakeDynamicClient keeps the objects in memory. Only the methods used by the restore are implemented.
+type fakeDynamicClient struct {
+	mu      sync.Mutex
+	objects map[fakeObjectKey]*unstructured.Unstructured
+	nextUID int
+}
+
+type fakeObjectKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// newFakeDynamicClient returns a client that serves the given objects of the resource.
+func newFakeDynamicClient(gvr schema.GroupVersionResource, objs ...*unstructured.Unstructured) *fakeDynamicClient {
+	c := &fakeDynamicClient{objects: make(map[fakeObjectKey]*unstructured.Unstructured)}
+	c.seed(gvr, objs...)
+	return c
+}
+
+// seed adds the objects of the resource.
+func (c *fakeDynamicClient) seed(gvr schema.GroupVersionResource, objs ...*unstructured.Unstructured) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, obj := range objs {
+		c.objects[fakeObjectKey{gvr: gvr, namespace: obj.GetNamespace(), name: obj.GetName()}] = obj.DeepCopy()
+	}
+}
+
+func (c *fakeDynamicClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &fakeResourceClient{client: c, gvr: gvr}
+}
+
+type fakeResourceClient struct {
+	dynamic.NamespaceableResourceInterface
+	client    *fakeDynamicClient
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
+func (r *fakeResourceClient) Namespace(namespace string) dynamic.ResourceInterface {
+	return &fakeResourceClient{client: r.client, gvr: r.gvr, namespace: namespace}
+}
+
+func (r *fakeResourceClient) key(name string) fakeObjectKey {
+	return fakeObjectKey{gvr: r.gvr, namespace: r.namespace, name: name}
+}
+
+func (r *fakeResourceClient) Create(_ context.Context, obj *unstructured.Unstructured, _ metav1.CreateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	key := r.key(obj.GetName())
+	if _, ok := r.client.objects[key]; ok {
+		return nil, kerr.NewAlreadyExists(r.gvr.GroupResource(), obj.GetName())
+	}
+	r.client.nextUID++
+	created := obj.DeepCopy()
+	created.SetUID(types.UID(fmt.Sprintf("created-%d", r.client.nextUID)))
+	created.SetResourceVersion("1")
+	r.client.objects[key] = created
+	return created.DeepCopy(), nil
+}
+
+func (r *fakeResourceClient) Get(_ context.Context, name string, _ metav1.GetOptions, _ ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	obj, ok := r.client.objects[r.key(name)]
+	if !ok {
+		return nil, kerr.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (r *fakeResourceClient) Update(_ context.Context, obj *unstructured.Unstructured, _ metav1.UpdateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	key := r.key(obj.GetName())
+	live, ok := r.client.objects[key]
+	if !ok {
+		return nil, kerr.NewNotFound(r.gvr.GroupResource(), obj.GetName())
+	}
+	if obj.GetResourceVersion() != live.GetResourceVersion() {
+		return nil, kerr.NewConflict(r.gvr.GroupResource(), obj.GetName(), fmt.Errorf("resource version %s is outdated", obj.GetResourceVersion()))
+	}
+	updated := obj.DeepCopy()
+	updated.SetUID(live.GetUID())
+	updated.SetResourceVersion(live.GetResourceVersion() + "1")
+	r.client.objects[key] = updated
+	return updated.DeepCopy(), nil
+}
+
+// Apply merges the fields of the object into the live object, like a forced server-side apply.
+func (r *fakeResourceClient) Apply(_ context.Context, name string, obj *unstructured.Unstructured, _ metav1.ApplyOptions, _ ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	key := r.key(name)
+	live, ok := r.client.objects[key]
+	if !ok {
+		return nil, kerr.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	applied := live.DeepCopy()
+	mergeFields(applied.Object, obj.DeepCopy().Object)
+	applied.SetResourceVersion(live.GetResourceVersion() + "1")
+	r.client.objects[key] = applied
+	return applied.DeepCopy(), nil
+}
+
+func mergeFields(dst, src map[string]any) {
+	for k, v := range src {
+		if m, ok := v.(map[string]any); ok {
+			if d, ok := dst[k].(map[string]any); ok {
+				mergeFields(d, m)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+func newConfigMap(name string, uid types.UID, data map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]any{"name": name, "namespace": "demo"},
+		"data":       data,
+	}}
+	if uid != "" {
+		obj.SetUID(uid)
+		obj.SetResourceVersion("5")
+	}
+	return obj
+}
+
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Node"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+func Test_restoreWave_conflictPolicy(t *testing.T) {
+	live := newConfigMap("config", "live-uid", map[string]any{"a": "live", "b": "live"})
+	tests := []struct {
+		policy     ConflictPolicy
+		wantAction RestoreAction
+		wantErr    bool
+		wantData   map[string]any
+		wantWave   WaveReport
+	}{
+		{
+			policy:     ConflictPolicySkip,
+			wantAction: RestoreActionSkipped,
+			wantData:   map[string]any{"a": "live", "b": "live"},
+			wantWave:   WaveReport{Name: "config", Restored: 1, Skipped: 1},
+		},
+		{
+			policy:     ConflictPolicyOverwrite,
+			wantAction: RestoreActionOverwritten,
+			wantData:   map[string]any{"a": "backup"},
+			wantWave:   WaveReport{Name: "config", Restored: 2},
+		},
+		{
+			policy:     ConflictPolicyMerge,
+			wantAction: RestoreActionMerged,
+			wantData:   map[string]any{"a": "backup", "b": "live"},
+			wantWave:   WaveReport{Name: "config", Restored: 2},
+		},
+		{
+			policy:     ConflictPolicyFail,
+			wantAction: RestoreActionFailed,
+			wantErr:    true,
+			wantData:   map[string]any{"a": "live", "b": "live"},
+			wantWave:   WaveReport{Name: "config", Restored: 1, Failed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			client := newFakeDynamicClient(configMapGVR, live)
+			opt := &resourceRestoreManager{
+				conflictPolicy: tt.policy,
+				di:             client,
+				mapper:         newTestRESTMapper(),
+				uids:           make(map[objectID]types.UID),
+			}
+			wave := restoreWave{name: "config", items: []restoreItem{
+				{path: "ConfigMap/config.yaml", obj: newConfigMap("config", "", map[string]any{"a": "backup"})},
+				{path: "ConfigMap/new.yaml", obj: newConfigMap("new", "", map[string]any{"a": "backup"})},
+			}}
+
+			report := &RestoreReport{ConflictPolicy: tt.policy}
+			err := opt.restoreWave(wave, report)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreWave() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(report.Waves, []WaveReport{tt.wantWave}) {
+				t.Errorf("waves = %+v, want %+v", report.Waves, tt.wantWave)
+			}
+			if len(report.Objects) != 2 {
+				t.Fatalf("objects = %+v, want an entry per object", report.Objects)
+			}
+			conflicting, created := report.Objects[0], report.Objects[1]
+			if conflicting.Name != "config" || conflicting.Path != "ConfigMap/config.yaml" || conflicting.Action != tt.wantAction {
+				t.Errorf("conflicting object = %+v, want action %s", conflicting, tt.wantAction)
+			}
+			if (conflicting.Error != "") != tt.wantErr {
+				t.Errorf("conflicting object error = %q, wantErr %v", conflicting.Error, tt.wantErr)
+			}
+			if created.Name != "new" || created.Action != RestoreActionCreated || created.Error != "" {
+				t.Errorf("new object = %+v, want it created", created)
+			}
+
+			obj, _ := client.Resource(configMapGVR).Namespace("demo").Get(context.TODO(), "config", metav1.GetOptions{})
+			if data, _, _ := unstructured.NestedMap(obj.Object, "data"); !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("live data = %v, want %v", data, tt.wantData)
+			}
+			if obj.GetUID() != "live-uid" {
+				t.Errorf("live object has been replaced, its UID is %s", obj.GetUID())
+			}
+			// the UIDs of the live objects are recorded for the owner references of their dependants
+			if uid := opt.uids[objectIDOf(wave.items[0].obj)]; uid != "live-uid" {
+				t.Errorf("recorded UID = %q, want the UID of the live object", uid)
+			}
+			if uid := opt.uids[objectIDOf(wave.items[1].obj)]; uid != "created-1" {
+				t.Errorf("recorded UID = %q, want the UID of the created object", uid)
+			}
+		})
+	}
+}
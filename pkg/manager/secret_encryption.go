@@ -0,0 +1,318 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// EncryptionAnnotation is set on the dumped Secrets whose data have been encrypted. Its value is the algorithm.
+	EncryptionAnnotation = "kubedump.stash.appscode.com/encryption"
+	// EncryptedDataKeyAnnotation holds the data key of an encrypted Secret, encrypted with the encryption key.
+	EncryptedDataKeyAnnotation = "kubedump.stash.appscode.com/encrypted-data-key"
+
+	encryptionAlgorithm = "AES-256-GCM"
+	// EncryptionKeySize is the size of the encryption key in bytes
+	EncryptionKeySize = 32
+)
+
+// encryptedAnnotations are the annotations of a Secret that hold its data, so they are encrypted along with it
+var encryptedAnnotations = []string{core.LastAppliedConfigAnnotation}
+
+// secretEncryptor envelope encrypts the data of the Secrets. Every Secret is encrypted with its own random
+// data key, which is encrypted with the encryption key and stored in the annotations of the Secret. The keys
+// of the data and the rest of the object are left readable. A nil encryptor leaves the objects unchanged.
+type secretEncryptor struct {
+	key cipher.AEAD
+}
+
+// newSecretEncryptor returns the encryptor of the key. It returns nil if the key is empty.
+func newSecretEncryptor(key []byte) (*secretEncryptor, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes long, found %d bytes", EncryptionKeySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &secretEncryptor{key: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func isSecret(obj map[string]any) bool {
+	u := unstructured.Unstructured{Object: obj}
+	return u.GetAPIVersion() == "v1" && u.GetKind() == "Secret"
+}
+
+// encrypt returns a copy of the Secret with its data encrypted. The other objects are returned as is.
+func (e *secretEncryptor) encrypt(in map[string]any) (map[string]any, error) {
+	if e == nil || !isSecret(in) {
+		return in, nil
+	}
+	data, _, err := unstructured.NestedStringMap(in, "data")
+	if err != nil {
+		return nil, err
+	}
+	out := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(in)}
+	annotations := out.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if len(data) == 0 && !hasEncryptedAnnotations(annotations) {
+		return in, nil
+	}
+
+	dataKey := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range data {
+		plaintext, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data %s of Secret %s/%s: %w", k, out.GetNamespace(), out.GetName(), err)
+		}
+		sealed, err := seal(aead, plaintext, additionalData(out, k))
+		if err != nil {
+			return nil, err
+		}
+		data[k] = base64.StdEncoding.EncodeToString(sealed)
+	}
+	if len(data) > 0 {
+		if err := unstructured.SetNestedStringMap(out.Object, data, "data"); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range encryptedAnnotations {
+		v, ok := annotations[k]
+		if !ok {
+			continue
+		}
+		sealed, err := seal(aead, []byte(v), additionalData(out, annotationKey(k)))
+		if err != nil {
+			return nil, err
+		}
+		annotations[k] = base64.StdEncoding.EncodeToString(sealed)
+	}
+
+	wrapped, err := seal(e.key, dataKey, additionalData(out, ""))
+	if err != nil {
+		return nil, err
+	}
+	annotations[EncryptionAnnotation] = encryptionAlgorithm
+	annotations[EncryptedDataKeyAnnotation] = base64.StdEncoding.EncodeToString(wrapped)
+	out.SetAnnotations(annotations)
+	return out.Object, nil
+}
+
+// decrypt decrypts the data of an encrypted Secret in place and removes the encryption annotations.
+// It must be called before the namespace of the Secret is remapped as the namespace is authenticated.
+func (e *secretEncryptor) decrypt(obj *unstructured.Unstructured) error {
+	annotations := obj.GetAnnotations()
+	algorithm, ok := annotations[EncryptionAnnotation]
+	if !ok {
+		return nil
+	}
+	if e == nil {
+		return errors.New("the Secret is encrypted but no encryption key has been given")
+	}
+	if algorithm != encryptionAlgorithm {
+		return fmt.Errorf("unknown encryption algorithm %q", algorithm)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(annotations[EncryptedDataKeyAnnotation])
+	if err != nil {
+		return fmt.Errorf("invalid data key: %w", err)
+	}
+	dataKey, err := open(e.key, wrapped, additionalData(obj, ""))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the data key, the encryption key may be wrong: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		sealed, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("invalid data %s: %w", k, err)
+		}
+		plaintext, err := open(aead, sealed, additionalData(obj, k))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt data %s: %w", k, err)
+		}
+		data[k] = base64.StdEncoding.EncodeToString(plaintext)
+	}
+	if len(data) > 0 {
+		if err := unstructured.SetNestedStringMap(obj.Object, data, "data"); err != nil {
+			return err
+		}
+	}
+	for _, k := range encryptedAnnotations {
+		v, ok := annotations[k]
+		if !ok {
+			continue
+		}
+		sealed, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", k, err)
+		}
+		plaintext, err := open(aead, sealed, additionalData(obj, annotationKey(k)))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt annotation %s: %w", k, err)
+		}
+		annotations[k] = string(plaintext)
+	}
+
+	delete(annotations, EncryptionAnnotation)
+	delete(annotations, EncryptedDataKeyAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// reuse returns the Secret with the ciphertexts of its previously stored encryption, if that decrypts to
+// the same object. The data key and the nonces are random, so an unchanged Secret would otherwise be
+// stored with a different content on every dump.
+func (e *secretEncryptor) reuse(in map[string]any, previous []byte) map[string]any {
+	prev := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(previous, &prev.Object); err != nil || prev.Object == nil {
+		return nil
+	}
+	if _, ok := prev.GetAnnotations()[EncryptionAnnotation]; !ok {
+		return nil
+	}
+	decrypted := prev.DeepCopy()
+	if err := e.decrypt(decrypted); err != nil {
+		return nil
+	}
+	want, err := yaml.Marshal(in)
+	if err != nil {
+		return nil
+	}
+	got, err := yaml.Marshal(decrypted.Object)
+	if err != nil || !bytes.Equal(got, want) {
+		return nil
+	}
+
+	out := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(in)}
+	data, _, err := unstructured.NestedStringMap(prev.Object, "data")
+	if err != nil {
+		return nil
+	}
+	if len(data) > 0 {
+		if err := unstructured.SetNestedStringMap(out.Object, data, "data"); err != nil {
+			return nil
+		}
+	}
+	annotations := out.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	prevAnnotations := prev.GetAnnotations()
+	for _, k := range append([]string{EncryptionAnnotation, EncryptedDataKeyAnnotation}, encryptedAnnotations...) {
+		if v, ok := prevAnnotations[k]; ok {
+			annotations[k] = v
+		}
+	}
+	out.SetAnnotations(annotations)
+	return out.Object
+}
+
+// encryptItem encrypts the Secret that is stored in the file. If the storage can read back the previous
+// dump, the ciphertexts of an unchanged Secret are reused so that it is not rewritten.
+func (e *secretEncryptor) encryptItem(in map[string]any, fileName string, storage Writer) (map[string]any, error) {
+	if e == nil || !isSecret(in) {
+		return in, nil
+	}
+	if r, ok := storage.(Reader); ok {
+		if previous, err := r.Read(fileName); err == nil {
+			if out := e.reuse(in, previous); out != nil {
+				return out, nil
+			}
+		}
+	}
+	return e.encrypt(in)
+}
+
+func hasEncryptedAnnotations(annotations map[string]string) bool {
+	for _, k := range encryptedAnnotations {
+		if _, ok := annotations[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// annotationKey is the key of an annotation in the additional data. The keys of the data of a Secret can't contain a slash.
+func annotationKey(k string) string {
+	return "metadata.annotations/" + k
+}
+
+// additionalData binds a ciphertext to the Secret and the data key so that it can't be moved to another one.
+func additionalData(obj *unstructured.Unstructured, key string) []byte {
+	return []byte(obj.GetNamespace() + "/" + obj.GetName() + "/" + key)
+}
+
+// seal encrypts the plaintext and prepends the random nonce to the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
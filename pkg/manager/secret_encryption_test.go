@@ -0,0 +1,201 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_secretEncryptor(t *testing.T) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	e, err := newSecretEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      "db",
+			"namespace": "demo",
+		},
+		"data": map[string]any{
+			"password": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+		},
+	}
+	original := unstructured.Unstructured{Object: secret}
+	original = *original.DeepCopy()
+
+	encrypted, err := e.encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(secret, original.Object) {
+		t.Error("encrypt modified the given object")
+	}
+	data, _, _ := unstructured.NestedStringMap(encrypted, "data")
+	value, ok := data["password"]
+	if !ok {
+		t.Fatalf("data keys = %v, want the password key to stay readable", data)
+	}
+	if raw, _ := base64.StdEncoding.DecodeString(value); bytes.Contains(raw, []byte("s3cr3t")) {
+		t.Error("the data of the Secret has not been encrypted")
+	}
+
+	wrongKey := make([]byte, EncryptionKeySize)
+	wrong, _ := newSecretEncryptor(wrongKey)
+	if err := wrong.decrypt(&unstructured.Unstructured{Object: deepCopy(encrypted)}); err == nil {
+		t.Error("expected an error for the wrong key")
+	}
+	if err := (*secretEncryptor)(nil).decrypt(&unstructured.Unstructured{Object: deepCopy(encrypted)}); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+	moved := &unstructured.Unstructured{Object: deepCopy(encrypted)}
+	moved.SetNamespace("other")
+	if err := e.decrypt(moved); err == nil {
+		t.Error("expected an error for a Secret moved to another namespace")
+	}
+
+	decrypted := &unstructured.Unstructured{Object: encrypted}
+	if err := e.decrypt(decrypted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decrypted.Object, original.Object) {
+		t.Errorf("decrypted = %v, want %v", decrypted.Object, original.Object)
+	}
+
+	configMap := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]any{"name": "config"},
+		"data":       map[string]any{"key": "value"},
+	}
+	out, err := e.encrypt(configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, configMap) {
+		t.Errorf("encrypt changed a ConfigMap: %v", out)
+	}
+
+	if _, err := newSecretEncryptor([]byte("short")); err == nil {
+		t.Error("expected an error for a key of the wrong size")
+	}
+}
+
+func Test_secretEncryptor_lastAppliedConfiguration(t *testing.T) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	e, err := newSecretEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastApplied := `{"apiVersion":"v1","kind":"Secret","stringData":{"password":"s3cr3t"}}`
+	secret := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      "db",
+			"namespace": "demo",
+			"annotations": map[string]any{
+				core.LastAppliedConfigAnnotation: lastApplied,
+			},
+		},
+	}
+	encrypted, err := e.encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &unstructured.Unstructured{Object: encrypted}
+	if v := out.GetAnnotations()[core.LastAppliedConfigAnnotation]; strings.Contains(v, "s3cr3t") {
+		t.Errorf("the last applied configuration has not been encrypted: %s", v)
+	}
+	if err := e.decrypt(out); err != nil {
+		t.Fatal(err)
+	}
+	if v := out.GetAnnotations()[core.LastAppliedConfigAnnotation]; v != lastApplied {
+		t.Errorf("last applied configuration = %s, want %s", v, lastApplied)
+	}
+}
+
+func Test_secretEncryptor_encryptItem(t *testing.T) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	e, err := newSecretEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "Secret", "db.yaml")
+	secret := func(password string) map[string]any {
+		return map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]any{"name": "db", "namespace": "demo"},
+			"data":       map[string]any{"password": base64.StdEncoding.EncodeToString([]byte(password))},
+		}
+	}
+	dump := func(obj map[string]any) *Changelog {
+		t.Helper()
+		w, err := NewIncrementalWriter(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := e.encryptItem(obj, fileName, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storeItem(fileName, data, w); err != nil {
+			t.Fatal(err)
+		}
+		changelog, err := w.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changelog
+	}
+
+	dump(secret("s3cr3t"))
+	if c := dump(secret("s3cr3t")); c.Unchanged != 1 {
+		t.Errorf("changelog = %+v, want the unchanged Secret not to be rewritten", c)
+	}
+	if c := dump(secret("changed")); len(c.Modified) != 1 {
+		t.Errorf("changelog = %+v, want the changed Secret to be rewritten", c)
+	}
+}
+
+func deepCopy(obj map[string]any) map[string]any {
+	return (&unstructured.Unstructured{Object: obj}).DeepCopy().Object
+}
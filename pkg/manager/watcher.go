@@ -0,0 +1,639 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// JournalFileName is the name of the journal that the watcher is currently writing to
+	JournalFileName = "journal.jsonl"
+	// WatchSnapshotDir is the directory of the watcher that is pushed as a snapshot. It contains the
+	// compacted dump in resources/ and the journal segments written since the last pushed snapshot in journal/.
+	WatchSnapshotDir = "snapshot"
+
+	// JournalStarted marks the start of a watcher. Every object is journaled again as added after it.
+	JournalStarted watch.EventType = "STARTED"
+	// JournalSynced marks the end of the initial listing of the objects after a start. The objects that
+	// have not been journaled since the start do not exist anymore.
+	JournalSynced watch.EventType = "SYNCED"
+
+	// informerSyncTimeout is how long the watcher waits for the informers to list their resources
+	informerSyncTimeout = 5 * time.Minute
+)
+
+// JournalEntry is a change of an object observed by the watcher. A journal is stored as JSON lines.
+type JournalEntry struct {
+	Time            metav1.Time     `json:"time"`
+	Type            watch.EventType `json:"type"`
+	GroupVersion    string          `json:"groupVersion"`
+	Resource        string          `json:"resource"`
+	Namespace       string          `json:"namespace,omitempty"`
+	Name            string          `json:"name"`
+	UID             types.UID       `json:"uid,omitempty"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	// Path is the file of the object relative to the root of the compacted dump
+	Path string `json:"path,omitempty"`
+	// Object is the sanitized object. It is the last known state of a deleted object.
+	Object map[string]any `json:"object,omitempty"`
+}
+
+// Watcher keeps informers on the selected resources and writes their changes into a journal. The journal is
+// compacted into a full dump that is laid out like the dump of the generic resource backup manager. A snapshot
+// holds the compacted dump as of the previous snapshot along with the journal segments written since then, so
+// ReplayJournal can reconstruct the state of the resources at any point between the two snapshots.
+type Watcher struct {
+	opt BackupOptions
+	dir string
+	// dumper decides where the objects of the compacted dump are stored
+	dumper itemDumper
+
+	encryptor *secretEncryptor
+	// syncTimeout is how long Start waits for the informers to sync
+	syncTimeout time.Duration
+	// warnings are the resources that are not watched as their informers failed to sync
+	warnings []DumpWarning
+
+	mu      sync.Mutex
+	journal *os.File
+	enc     *json.Encoder
+}
+
+func NewWatcher(opt BackupOptions, dir string) *Watcher {
+	return &Watcher{
+		opt: opt,
+		dir: dir,
+		dumper: itemDumper{
+			dataDir:        filepath.Join(dir, WatchSnapshotDir, "resources"),
+			useRootDataDir: opt.Target.Kind == apis.KindNamespace && len(opt.Namespaces) == 0,
+		},
+		syncTimeout: informerSyncTimeout,
+	}
+}
+
+// SnapshotDir returns the directory that has to be pushed after a compaction.
+func (w *Watcher) SnapshotDir() string {
+	return filepath.Join(w.dir, WatchSnapshotDir)
+}
+
+func (w *Watcher) segmentDir() string {
+	return filepath.Join(w.SnapshotDir(), "journal")
+}
+
+// Start starts the informers of the selected resources and waits until their caches are synced. Every
+// object is journaled as added once the informers start, and the objects of the compacted dump that are
+// not journaled again until the caches are synced are deleted when the journal is replayed.
+// The informers are stopped when the context is done.
+//
+// An informer that fails to list its resource, or that does not sync in time, fails the watcher in strict
+// mode. Otherwise, it is stopped and the resource is reported as a warning.
+func (w *Watcher) Start(ctx context.Context) error {
+	rp := resourceProcessor{
+		config:            w.opt.Config,
+		selector:          w.opt.Selector,
+		ignoreGroupKinds:  w.opt.IgnoreGroupKinds,
+		includeResources:  w.opt.IncludeResources,
+		excludeResources:  w.opt.ExcludeResources,
+		includeNamespaces: w.opt.IncludeNamespaces,
+		excludeNamespaces: w.opt.ExcludeNamespaces,
+		namespaceSelector: w.opt.NamespaceSelector,
+		strict:            w.opt.Strict,
+		stats:             newBackupStats(),
+	}
+	switch {
+	case len(w.opt.Namespaces) > 0:
+		rp.targetNamespaces = w.opt.Namespaces
+		if w.opt.Target.Kind == apis.KindNamespace && !slices.Contains(w.opt.Namespaces, w.opt.Target.Name) {
+			rp.targetNamespaces = append([]string{w.opt.Target.Name}, w.opt.Namespaces...)
+		}
+	case w.opt.Target.Kind == apis.KindNamespace:
+		rp.namespace = w.opt.Target.Name
+	case w.opt.Target.Kind != "":
+		return fmt.Errorf("watching %s is not supported, the target must be the whole cluster or a namespace", w.opt.Target.Kind)
+	}
+
+	var err error
+	w.encryptor, err = newSecretEncryptor(w.opt.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	if err := w.opt.SecretPolicy.Validate(); err != nil {
+		return err
+	}
+
+	// the journal left by a previous run is kept as a segment of the next snapshot
+	if _, err := w.rotate(); err != nil {
+		return err
+	}
+	if err := w.mark(JournalStarted); err != nil {
+		return err
+	}
+
+	tasks, err := rp.discoverResources()
+	if err != nil {
+		return err
+	}
+	resources := make([]*watchedResource, 0, len(tasks))
+	for _, task := range tasks {
+		r, err := w.watch(ctx, &rp, task)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, r)
+	}
+	klog.Infof("Watching %d resources", len(tasks))
+	if err := w.waitForSync(ctx, resources); err != nil {
+		return err
+	}
+	return w.mark(JournalSynced)
+}
+
+// waitForSync waits for the informers of the resources to sync. The resources whose informers fail are
+// stopped and reported as warnings, unless the watcher is strict.
+func (w *Watcher) waitForSync(ctx context.Context, resources []*watchedResource) error {
+	syncCtx, cancel := context.WithTimeout(ctx, w.syncTimeout)
+	defer cancel()
+	for _, r := range resources {
+		err := r.waitForSync(syncCtx)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.stop()
+		if w.opt.Strict {
+			return fmt.Errorf("failed to watch resource %s: %w", r.task.gvr, err)
+		}
+		klog.Warningf("Skipping resource %s as it can't be watched: %v", r.task.gvr, err)
+		w.warnings = append(w.warnings, DumpWarning{
+			GroupVersion: r.task.gvr.GroupVersion().String(),
+			Resource:     r.task.gvr.Resource,
+			Namespace:    r.task.namespace,
+			Reason:       err.Error(),
+		})
+	}
+	return nil
+}
+
+// Warnings returns the resources that are not watched as their informers failed to sync.
+func (w *Watcher) Warnings() []DumpWarning {
+	return w.warnings
+}
+
+// watchedResource is the informer of a resource.
+type watchedResource struct {
+	task      resourceTask
+	hasSynced cache.InformerSynced
+	// failed receives the first error of the informer before it has synced
+	failed chan error
+	stop   context.CancelFunc
+}
+
+// waitForSync waits until the informer has synced. It returns the error of the informer if it fails to
+// list the resource, or if the context is done first.
+func (r *watchedResource) waitForSync(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !r.hasSynced() {
+		select {
+		case err := <-r.failed:
+			return err
+		case <-ctx.Done():
+			return errors.New("timed out waiting for the informer to sync")
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// watch starts the informer of the resource. The informer is stopped when the context is done.
+func (w *Watcher) watch(ctx context.Context, rp *resourceProcessor, task resourceTask) (*watchedResource, error) {
+	ctx, stop := context.WithCancel(ctx)
+	informer, err := w.newInformer(ctx, rp, task)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	r := &watchedResource{
+		task:      task,
+		hasSynced: informer.HasSynced,
+		failed:    make(chan error, 1),
+		stop:      stop,
+	}
+	err = informer.SetWatchErrorHandlerWithContext(func(ctx context.Context, reflector *cache.Reflector, err error) {
+		if !informer.HasSynced() {
+			select {
+			case r.failed <- err:
+			default:
+			}
+		}
+		cache.DefaultWatchErrorHandler(ctx, reflector, err)
+	})
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	go informer.Run(ctx.Done())
+	return r, nil
+}
+
+func (w *Watcher) newInformer(ctx context.Context, rp *resourceProcessor, task resourceTask) (cache.SharedIndexInformer, error) {
+	ri := rp.di.Resource(task.gvr).Namespace(task.namespace)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = rp.selector
+			return ri.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = rp.selector
+			return ri.Watch(ctx, options)
+		},
+	}
+	informer := cache.NewSharedIndexInformer(lw, &unstructured.Unstructured{}, 0, cache.Indexers{})
+
+	record := func(eventType watch.EventType, obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		if task.gvr == namespaceGVR && rp.namespaces != nil && !rp.namespaces.Has(u.GetName()) {
+			return
+		}
+		if err := w.record(eventType, u, task.gvr); err != nil {
+			klog.Errorf("Failed to journal %s %s: %v", task.gvr, objectKey(u), err)
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			record(watch.Added, obj)
+		},
+		UpdateFunc: func(oldObj, newObj any) {
+			if oldObj.(*unstructured.Unstructured).GetResourceVersion() == newObj.(*unstructured.Unstructured).GetResourceVersion() {
+				return
+			}
+			record(watch.Modified, newObj)
+		},
+		DeleteFunc: func(obj any) {
+			record(watch.Deleted, obj)
+		},
+	})
+	return informer, err
+}
+
+// record appends the change of the object to the journal.
+func (w *Watcher) record(eventType watch.EventType, obj *unstructured.Unstructured, gvr schema.GroupVersionResource) error {
+	// the objects of the informer cache must not be modified
+	data, err := sanitizeObject(obj.DeepCopy().Object, obj.GetKind(), w.opt.Sanitize, w.opt.SecretPolicy)
+	if err != nil || data == nil {
+		return err
+	}
+	data, err = w.encryptor.encrypt(data)
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Rel(w.dumper.dataDir, w.dumper.getFileName(unstructured.Unstructured{Object: data}))
+	if err != nil {
+		return err
+	}
+	return w.write(JournalEntry{
+		Type:            eventType,
+		GroupVersion:    gvr.GroupVersion().String(),
+		Resource:        gvr.Resource,
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		UID:             obj.GetUID(),
+		ResourceVersion: obj.GetResourceVersion(),
+		Path:            filepath.ToSlash(path),
+		Object:          data,
+	})
+}
+
+// mark appends a marker to the journal.
+func (w *Watcher) mark(eventType watch.EventType) error {
+	return w.write(JournalEntry{Type: eventType})
+}
+
+// write timestamps the entry and appends it to the journal.
+func (w *Watcher) write(entry JournalEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.enc == nil {
+		return errors.New("journal is closed")
+	}
+	entry.Time = metav1.Now()
+	return w.enc.Encode(entry)
+}
+
+// rotate moves the current journal, if any, into a new segment and opens a new journal. It returns
+// the path of the segment, or an empty string if the journal was empty.
+func (w *Watcher) rotate() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.journal != nil {
+		if err := w.journal.Sync(); err != nil {
+			return "", err
+		}
+		if err := w.journal.Close(); err != nil {
+			return "", err
+		}
+		w.journal, w.enc = nil, nil
+	}
+	if err := os.MkdirAll(w.segmentDir(), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	journal := filepath.Join(w.dir, JournalFileName)
+	var segment string
+	if info, err := os.Stat(journal); err == nil && info.Size() > 0 {
+		segment = filepath.Join(w.segmentDir(), time.Now().UTC().Format("20060102T150405.000000000Z")+".jsonl")
+		if err := os.Rename(journal, segment); err != nil {
+			return "", err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	f, err := os.OpenFile(journal, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	w.journal, w.enc = f, json.NewEncoder(f)
+	return segment, nil
+}
+
+// Compact rotates the journal. It returns the journal segments that are waiting to be pushed along with the
+// compacted dump. The segments are applied to the compacted dump by Commit once they have been pushed.
+func (w *Watcher) Compact() ([]string, error) {
+	if _, err := w.rotate(); err != nil {
+		return nil, err
+	}
+	return listSegments(w.segmentDir())
+}
+
+// Commit applies the pushed journal segments to the compacted dump, writes the index of the compacted dump
+// and removes the segments.
+func (w *Watcher) Commit(segments []string) error {
+	r, err := newJournalReplay(w.dumper.dataDir, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := r.apply(s); err != nil {
+			return err
+		}
+	}
+	if err := r.writeIndex(w.opt.SigningKey); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if err := os.Remove(s); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayJournal replays the journal segments of a snapshot pushed by the watcher on its compacted dump, up to
+// the given time or entirely if the time is zero. It returns the directory of the replayed dump, whose index is
+// rewritten without a signature as it does not describe the pushed dump anymore.
+func ReplayJournal(snapshotDir string, until time.Time) (string, error) {
+	dataDir := filepath.Join(snapshotDir, "resources")
+	segments, err := listSegments(filepath.Join(snapshotDir, "journal"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	r, err := newJournalReplay(dataDir, until)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range segments {
+		done, err := r.apply(s)
+		if err != nil {
+			return "", err
+		}
+		if done {
+			break
+		}
+	}
+	klog.Infof("Replayed %d journal entries", r.replayed)
+	return dataDir, r.writeIndex(nil)
+}
+
+// listSegments returns the journal segments of the directory in the order they have been written.
+func listSegments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// os.ReadDir sorts the entries by name, which is the time of the rotation
+	segments := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			segments = append(segments, filepath.Join(dir, e.Name()))
+		}
+	}
+	return segments, nil
+}
+
+// journalReplay applies journal entries to a compacted dump and keeps its index up to date.
+type journalReplay struct {
+	dataDir string
+	// until is the time of the last entry to apply. Every entry is applied if it is zero.
+	until time.Time
+	// index holds the entries of the index of the dump by their paths
+	index map[string]IndexEntry
+	// journaled holds the paths of the objects journaled since the last start of the watcher, if any
+	journaled map[string]bool
+	replayed  int
+}
+
+func newJournalReplay(dataDir string, until time.Time) (*journalReplay, error) {
+	r := &journalReplay{
+		dataDir: dataDir,
+		until:   until,
+		index:   map[string]IndexEntry{},
+	}
+	data, err := os.ReadFile(filepath.Join(dataDir, IndexFileName))
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index of the compacted dump: %w", err)
+	}
+	for _, entry := range index.Objects {
+		r.index[entry.Path] = entry
+	}
+	return r, nil
+}
+
+// apply applies the entries of the journal segment. It reports whether an entry later than the time of the
+// replay has been reached, in which case the following segments must not be applied either.
+func (r *journalReplay) apply(segment string) (bool, error) {
+	f, err := os.Open(segment)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// an entry holds a whole object, which can be as large as the maximum request size of the API server
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return false, fmt.Errorf("invalid entry in journal %s: %w", segment, err)
+		}
+		if !r.until.IsZero() && entry.Time.After(r.until) {
+			return true, nil
+		}
+		if err := r.applyEntry(entry); err != nil {
+			return false, fmt.Errorf("failed to apply entry of journal %s: %w", segment, err)
+		}
+		r.replayed++
+	}
+	return false, scanner.Err()
+}
+
+func (r *journalReplay) applyEntry(entry JournalEntry) error {
+	switch entry.Type {
+	case JournalStarted:
+		r.journaled = map[string]bool{}
+		return nil
+	case JournalSynced:
+		if r.journaled == nil {
+			return nil
+		}
+		err := r.deleteUnjournaled()
+		r.journaled = nil
+		return err
+	}
+
+	if entry.Path == "" {
+		return fmt.Errorf("missing path of %s %s/%s", entry.Resource, entry.Namespace, entry.Name)
+	}
+	fileName := filepath.Join(r.dataDir, filepath.FromSlash(entry.Path))
+	if entry.Type == watch.Deleted {
+		delete(r.index, entry.Path)
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := storeItem(fileName, entry.Object, NewFileWriter())
+	if err != nil {
+		return err
+	}
+	if r.journaled != nil {
+		r.journaled[entry.Path] = true
+	}
+	obj := unstructured.Unstructured{Object: entry.Object}
+	sum := sha256.Sum256(data)
+	r.index[entry.Path] = IndexEntry{
+		APIVersion:      obj.GetAPIVersion(),
+		Kind:            obj.GetKind(),
+		Namespace:       entry.Namespace,
+		Name:            entry.Name,
+		UID:             entry.UID,
+		ResourceVersion: entry.ResourceVersion,
+		Path:            entry.Path,
+		SHA256:          hex.EncodeToString(sum[:]),
+	}
+	return nil
+}
+
+// deleteUnjournaled deletes the objects of the dump that have not been journaled since the watcher started.
+func (r *journalReplay) deleteUnjournaled() error {
+	return filepath.WalkDir(r.dataDir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dataDir, path)
+		if err != nil {
+			return err
+		}
+		if r.journaled[filepath.ToSlash(rel)] {
+			return nil
+		}
+		delete(r.index, filepath.ToSlash(rel))
+		return os.Remove(path)
+	})
+}
+
+// writeIndex stores the index of the dump and signs it if a key is given.
+func (r *journalReplay) writeIndex(key ed25519.PrivateKey) error {
+	idx := newDumpIndex(r.dataDir)
+	for _, entry := range r.index {
+		idx.entries = append(idx.entries, entry)
+	}
+	if err := os.MkdirAll(r.dataDir, os.ModePerm); err != nil {
+		return err
+	}
+	return idx.write(NewFileWriter(), key)
+}
+
+// Close closes the journal. The changes observed afterwards are not journaled.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.journal == nil {
+		return nil
+	}
+	err := w.journal.Close()
+	w.journal, w.enc = nil, nil
+	return err
+}
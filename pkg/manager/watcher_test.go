@@ -0,0 +1,271 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/yaml"
+)
+
+func Test_watcherCompact(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWatcher(BackupOptions{}, dir)
+	if _, err := w.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	configMap := func(name, value string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("demo")
+		obj.SetName(name)
+		obj.Object["data"] = map[string]any{"key": value}
+		return obj
+	}
+	events := []struct {
+		eventType watch.EventType
+		obj       *unstructured.Unstructured
+	}{
+		{watch.Added, configMap("kept", "v1")},
+		{watch.Added, configMap("deleted", "v1")},
+		{watch.Modified, configMap("kept", "v2")},
+		{watch.Deleted, configMap("deleted", "v1")},
+	}
+	for _, e := range events {
+		if err := w.record(e.eventType, e.obj, configMapGVR); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, err := w.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+	dataDir := filepath.Join(dir, WatchSnapshotDir, "resources")
+	resources := filepath.Join(dataDir, "namespaces", "demo", "ConfigMap")
+	if _, err := os.Stat(filepath.Join(resources, "kept.yaml")); !os.IsNotExist(err) {
+		t.Errorf("the segment is applied to the compacted dump before it is pushed")
+	}
+
+	if err := w.Commit(segments); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(resources, "kept.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "key: v2") {
+		t.Errorf("kept.yaml does not have the latest data:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(resources, "deleted.yaml")); !os.IsNotExist(err) {
+		t.Errorf("deleted.yaml exists in the compacted dump")
+	}
+	result, err := VerifyIndex(dataDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() || result.Verified != 1 {
+		t.Errorf("VerifyIndex() = %+v, want the kept object indexed", result)
+	}
+
+	if segments, err = w.Compact(); err != nil || len(segments) != 0 {
+		t.Errorf("Compact() = %v, %v, want no segments", segments, err)
+	}
+}
+
+func Test_ReplayJournal(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) metav1.Time {
+		return metav1.NewTime(start.Add(time.Duration(minutes) * time.Minute))
+	}
+	configMap := func(minutes int, eventType watch.EventType, name, value string) JournalEntry {
+		return JournalEntry{
+			Time:         at(minutes),
+			Type:         eventType,
+			GroupVersion: "v1",
+			Resource:     "configmaps",
+			Namespace:    "demo",
+			Name:         name,
+			Path:         "namespaces/demo/ConfigMap/" + name + ".yaml",
+			Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"namespace": "demo", "name": name},
+				"data":       map[string]any{"key": value},
+			},
+		}
+	}
+	writeSegment := func(dir, name string, entries ...JournalEntry) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// newSnapshot lays out a snapshot whose compacted dump has the stale and the modified ConfigMaps,
+	// and whose journal restarts the watcher on which only the modified ConfigMap still exists
+	newSnapshot := func() string {
+		dir := t.TempDir()
+		r, err := newJournalReplay(filepath.Join(dir, "resources"), time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range []JournalEntry{configMap(0, watch.Added, "stale", "v1"), configMap(0, watch.Added, "modified", "v1")} {
+			if err := r.applyEntry(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.writeIndex(nil); err != nil {
+			t.Fatal(err)
+		}
+		journal := filepath.Join(dir, "journal")
+		writeSegment(journal, "20240101T000100.000000000Z.jsonl",
+			configMap(1, watch.Modified, "modified", "v2"),
+			JournalEntry{Time: at(2), Type: JournalStarted},
+			configMap(3, watch.Added, "modified", "v2"),
+		)
+		writeSegment(journal, "20240101T000400.000000000Z.jsonl",
+			JournalEntry{Time: at(4), Type: JournalSynced},
+			configMap(5, watch.Added, "created", "v1"),
+		)
+		return dir
+	}
+
+	tests := []struct {
+		name  string
+		until time.Time
+		want  map[string]string
+	}{
+		{
+			name: "end of the journal",
+			want: map[string]string{"modified": "v2", "created": "v1"},
+		},
+		{
+			name:  "before the watcher has synced",
+			until: at(3).Time,
+			want:  map[string]string{"stale": "v1", "modified": "v2"},
+		},
+		{
+			name:  "after the watcher has synced",
+			until: at(4).Time,
+			want:  map[string]string{"modified": "v2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir, err := ReplayJournal(newSnapshot(), tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			resources := filepath.Join(dataDir, "namespaces", "demo", "ConfigMap")
+			files, err := os.ReadDir(resources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range files {
+				data, err := os.ReadFile(filepath.Join(resources, f.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var obj map[string]any
+				if err := yaml.Unmarshal(data, &obj); err != nil {
+					t.Fatal(err)
+				}
+				got[strings.TrimSuffix(f.Name(), ".yaml")] = obj["data"].(map[string]any)["key"].(string)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayed objects = %v, want %v", got, tt.want)
+			}
+			result, err := VerifyIndex(dataDir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Valid() || result.Verified != len(tt.want) {
+				t.Errorf("VerifyIndex() = %+v, want the replayed objects indexed", result)
+			}
+		})
+	}
+}
+
+func Test_watcherWaitForSync(t *testing.T) {
+	newResource := func(resource string, synced bool, err error) *watchedResource {
+		r := &watchedResource{
+			task:      resourceTask{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: resource}},
+			hasSynced: func() bool { return synced },
+			failed:    make(chan error, 1),
+			stop:      func() {},
+		}
+		if err != nil {
+			r.failed <- err
+		}
+		return r
+	}
+	resources := func() []*watchedResource {
+		return []*watchedResource{
+			newResource("synced", true, nil),
+			newResource("forbidden", false, errors.New("forbidden")),
+			newResource("stuck", false, nil),
+		}
+	}
+
+	w := NewWatcher(BackupOptions{}, t.TempDir())
+	w.syncTimeout = 200 * time.Millisecond
+	if err := w.waitForSync(context.Background(), resources()); err != nil {
+		t.Fatal(err)
+	}
+	var skipped []string
+	for _, warning := range w.Warnings() {
+		skipped = append(skipped, warning.Resource)
+	}
+	if !reflect.DeepEqual(skipped, []string{"forbidden", "stuck"}) {
+		t.Errorf("skipped = %v, want the resources that failed to sync", skipped)
+	}
+
+	w = NewWatcher(BackupOptions{Strict: true}, t.TempDir())
+	w.syncTimeout = 200 * time.Millisecond
+	if err := w.waitForSync(context.Background(), resources()); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("err = %v, want the error of the forbidden resource in strict mode", err)
+	}
+}
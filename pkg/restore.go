@@ -0,0 +1,252 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stash "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	"stash.appscode.dev/apimachinery/pkg/invoker"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/kubedump/pkg/manager"
+
+	"github.com/spf13/cobra"
+	license "go.bytebuilders.dev/license-verifier/kubernetes"
+	"gomodules.xyz/flags"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+	v1 "kmodules.xyz/offshoot-api/api/v1"
+)
+
+func NewCmdRestore() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		opt            = options{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+			restoreOptions: restic.RestoreOptions{
+				Host: restic.DefaultHost,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:               "restore",
+		Short:             "Restores Kubernetes resources from a backup",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "storage-secret-name", "storage-secret-namespace")
+
+			// prepare client
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				return err
+			}
+			opt.config = config
+
+			opt.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			opt.stashClient, err = stash.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+
+			opt.encryptionKey, err = opt.loadEncryptionKey()
+			if err != nil {
+				return err
+			}
+
+			inv, err := invoker.NewRestoreInvoker(opt.kubeClient, opt.stashClient, opt.invokerKind, opt.invokerName, opt.namespace)
+			if err != nil {
+				return err
+			}
+
+			for _, ti := range inv.GetTargetInfo() {
+				if ti.Target != nil && opt.targetMatched(ti.Target.Ref, opt.targetRef) {
+					var (
+						restoreOutput *restic.RestoreOutput
+						report        *manager.RestoreReport
+					)
+					restoreOutput, report, err = opt.restoreResources(ti.Target.Ref)
+					if err != nil {
+						restoreOutput = &restic.RestoreOutput{
+							RestoreTargetStatus: v1beta1.RestoreMemberStatus{
+								Ref: ti.Target.Ref,
+								Stats: []v1beta1.HostRestoreStats{
+									{
+										Hostname: opt.restoreOptions.Host,
+										Phase:    v1beta1.HostRestoreFailed,
+										Error:    err.Error(),
+									},
+								},
+							},
+						}
+					}
+					// If output directory specified, then write the output in "output.json" file in the specified directory
+					if opt.outputDir != "" {
+						// the per object report is written even if the restore has failed so that the partial changes can be audited
+						if report != nil {
+							if err := writeJSONFile(filepath.Join(opt.outputDir, manager.RestoreReportFileName), report); err != nil {
+								return err
+							}
+						}
+						return restoreOutput.WriteOutput(filepath.Join(opt.outputDir, restic.DefaultOutputFileName))
+					}
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.namespace, "namespace", "default", "Namespace of Backup/Restore Session")
+	cmd.Flags().StringVar(&opt.storageSecret.Name, "storage-secret-name", opt.storageSecret.Name, "Name of the storage secret")
+	cmd.Flags().StringVar(&opt.storageSecret.Namespace, "storage-secret-namespace", opt.storageSecret.Namespace, "Namespace of the storage secret")
+
+	cmd.Flags().StringVar(&opt.setupOptions.Provider, "provider", opt.setupOptions.Provider, "Backend provider (i.e. gcs, s3, azure etc)")
+	cmd.Flags().StringVar(&opt.setupOptions.Bucket, "bucket", opt.setupOptions.Bucket, "Name of the cloud bucket/container (keep empty for local backend)")
+	cmd.Flags().StringVar(&opt.setupOptions.Endpoint, "endpoint", opt.setupOptions.Endpoint, "Endpoint for s3/s3 compatible backend or REST server URL")
+	cmd.Flags().BoolVar(&opt.setupOptions.InsecureTLS, "insecure-tls", opt.setupOptions.InsecureTLS, "InsecureTLS for TLS secure s3/s3 compatible backend")
+	cmd.Flags().StringVar(&opt.setupOptions.Region, "region", opt.setupOptions.Region, "Region for s3/s3 compatible backend")
+	cmd.Flags().StringVar(&opt.setupOptions.Path, "path", opt.setupOptions.Path, "Directory inside the bucket where backup will be stored")
+	cmd.Flags().StringVar(&opt.setupOptions.ScratchDir, "scratch-dir", opt.setupOptions.ScratchDir, "Temporary directory")
+	cmd.Flags().BoolVar(&opt.setupOptions.EnableCache, "enable-cache", opt.setupOptions.EnableCache, "Specify whether to enable caching for restic")
+	cmd.Flags().Int64Var(&opt.setupOptions.MaxConnections, "max-connections", opt.setupOptions.MaxConnections, "Specify maximum concurrent connections for GCS, Azure and B2 backend")
+
+	cmd.Flags().StringVar(&opt.restoreOptions.Host, "hostname", opt.restoreOptions.Host, "Name of the host machine")
+	cmd.Flags().StringVar(&opt.restoreOptions.SourceHost, "source-hostname", opt.restoreOptions.SourceHost, "Name of the host from where data will be restored")
+	cmd.Flags().StringSliceVar(&opt.restoreOptions.Snapshots, "snapshot", opt.restoreOptions.Snapshots, "Snapshot to restore (default is the latest snapshot)")
+	cmd.Flags().BoolVar(&opt.fromWatch, "from-watch", opt.fromWatch, "Specify whether to restore a snapshot pushed by the watch command. Its journal is replayed on its compacted dump before the resources are restored.")
+	cmd.Flags().StringVar(&opt.pointInTime, "point-in-time", opt.pointInTime, "Specify the time (RFC3339) to restore the resources of a snapshot pushed by the watch command to (default is the time of the snapshot). Implies --from-watch.")
+	cmd.Flags().StringVar(&opt.invokerKind, "invoker-kind", opt.invokerKind, "Kind of the restore invoker")
+	cmd.Flags().StringVar(&opt.invokerName, "invoker-name", opt.invokerName, "Name of the respective restore invoker")
+	cmd.Flags().StringVar(&opt.targetRef.Kind, "target-kind", opt.targetRef.Kind, "Kind of the Target")
+	cmd.Flags().StringVar(&opt.targetRef.Name, "target-name", opt.targetRef.Name, "Name of the Target")
+	cmd.Flags().StringVar(&opt.targetRef.Namespace, "target-namespace", opt.targetRef.Namespace, "Namespace of the Target")
+
+	cmd.Flags().StringVar(&opt.outputDir, "output-dir", opt.outputDir, "Directory where output.json file will be written (keep empty if you don't need to write output in file)")
+
+	cmd.Flags().StringVar((*string)(&opt.conflictPolicy), "conflict-policy", string(manager.ConflictPolicySkip), "Specify what to do when a resource already exists. Allowed values: skip, overwrite, fail, merge")
+	cmd.Flags().BoolVar(&opt.dryRun, "dry-run", false, "Specify whether to only preview the changes using server-side dry-run without writing anything")
+	cmd.Flags().StringToStringVar(&opt.namespaceMapping, "namespace-mapping", opt.namespaceMapping, "Specify the namespaces to restore the resources into as old=new pairs (i.e. prod=staging)")
+
+	cmd.Flags().StringSliceVar(&opt.restoreFilter.IncludeKinds, "include-kinds", opt.restoreFilter.IncludeKinds, "Specify the kinds to restore (i.e. Deployment,ConfigMap). Keep empty to restore all kinds.")
+	cmd.Flags().StringSliceVar(&opt.restoreFilter.ExcludeKinds, "exclude-kinds", opt.restoreFilter.ExcludeKinds, "Specify the kinds to skip from restore.")
+	cmd.Flags().StringSliceVar(&opt.restoreFilter.Names, "name", opt.restoreFilter.Names, "Specify glob patterns to select the resources to restore by name.")
+	cmd.Flags().StringVar(&opt.restoreFilter.Selector, "selector", opt.restoreFilter.Selector, "Specify a label selector to select the resources to restore by their backed up labels.")
+	cmd.Flags().StringSliceVar(&opt.restoreFilter.Namespaces, "namespaces", opt.restoreFilter.Namespaces, "Specify the namespaces of the backed up resources to restore. Cluster scoped resources are skipped except the respective Namespace objects.")
+
+	opt.addEncryptionFlags(cmd)
+
+	return cmd
+}
+
+func (opt *options) restoreResources(targetRef v1beta1.TargetRef) (*restic.RestoreOutput, *manager.RestoreReport, error) {
+	var err error
+	err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// apply nice, ionice settings from env
+	opt.setupOptions.Nice, err = v1.NiceSettingsFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	opt.setupOptions.IONice, err = v1.IONiceSettingsFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var until time.Time
+	if opt.pointInTime != "" {
+		until, err = time.Parse(time.RFC3339, opt.pointInTime)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid point in time: %w", err)
+		}
+		opt.fromWatch = true
+	}
+
+	// the resources were backed up from the interim data dir. restic will restore them into the same path.
+	opt.dataDir = filepath.Join(opt.setupOptions.ScratchDir, "resources")
+	if opt.fromWatch {
+		// the watcher pushes its compacted dump and journal from the snapshot dir of its scratch dir
+		opt.dataDir = filepath.Join(opt.setupOptions.ScratchDir, "watch", manager.WatchSnapshotDir)
+	}
+	klog.Infoln("Cleaning up directory: ", opt.dataDir)
+	if err := clearDir(opt.dataDir); err != nil {
+		return nil, nil, err
+	}
+	opt.restoreOptions.RestorePaths = []string{opt.dataDir}
+	// fetch only the files that may contain the selected resources
+	opt.restoreOptions.Include = opt.restoreFilter.IncludePatterns()
+	if opt.fromWatch && len(opt.restoreOptions.Include) > 0 {
+		// the journal holds the changes of the selected resources too
+		opt.restoreOptions.Include = append(opt.restoreOptions.Include, "**/journal/*.jsonl")
+	}
+
+	// init restic wrapper
+	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restoreOutput, err := resticWrapper.RunRestore(opt.restoreOptions, targetRef)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opt.fromWatch {
+		opt.dataDir, err = manager.ReplayJournal(opt.dataDir, until)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to replay the journal: %w", err)
+		}
+	}
+
+	mgr := manager.NewRestoreManager(manager.RestoreOptions{
+		Config:           opt.config,
+		DataDir:          opt.dataDir,
+		NamespaceMapping: opt.namespaceMapping,
+		ConflictPolicy:   opt.conflictPolicy,
+		DryRun:           opt.dryRun,
+		Filter:           opt.restoreFilter,
+		EncryptionKey:    opt.encryptionKey,
+	})
+	report, err := mgr.Restore()
+	if opt.dryRun && report != nil {
+		fmt.Print(report.FormatPreview())
+	}
+	if err != nil {
+		return nil, report, err
+	}
+	return restoreOutput, report, nil
+}
@@ -42,6 +42,10 @@ func NewRootCmd() *cobra.Command {
 
 	rootCmd.AddCommand(v.NewCmdVersion())
 	rootCmd.AddCommand(NewCmdBackup())
+	rootCmd.AddCommand(NewCmdRestore())
+	rootCmd.AddCommand(NewCmdDump())
+	rootCmd.AddCommand(NewCmdWatch())
+	rootCmd.AddCommand(NewCmdVerify())
 
 	return rootCmd
 }
@@ -16,12 +16,17 @@ limitations under the License.
 
 package sanitizers
 
+// Sanitizer removes the fields of an object that are populated by the API server. It returns nil if the
+// object must not be dumped at all.
 type Sanitizer interface {
 	Sanitize(in map[string]any) (map[string]any, error)
 }
 
-func NewSanitizer(kind string) Sanitizer {
+// NewSanitizer returns the sanitizer of the kind. The Secrets are sanitized according to the policy.
+func NewSanitizer(kind string, policy SecretPolicy) Sanitizer {
 	switch kind {
+	case "Secret":
+		return newSecretSanitizer(policy)
 	case "Pod":
 		return newPodSanitizer()
 	case "StatefulSet", "Deployment", "ReplicaSet", "DaemonSet", "ReplicationController", "Job":
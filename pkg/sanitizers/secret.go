@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sanitizers
+
+import (
+	"fmt"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SecretMode decides what is kept of the Secrets
+type SecretMode string
+
+const (
+	// SecretModeKeep keeps the Secrets as they are
+	SecretModeKeep SecretMode = "keep"
+	// SecretModeRedact keeps the keys of the data of the Secrets but empties their values
+	SecretModeRedact SecretMode = "redact"
+	// SecretModeSkip skips all the Secrets
+	SecretModeSkip SecretMode = "skip"
+
+	// helmReleaseSecretType is the type of the Secrets that Helm 3 stores its releases in
+	helmReleaseSecretType = "helm.sh/release.v1"
+)
+
+// DefaultSkippedSecretTypes are the types of the Secrets that are generated by the cluster or a tool and
+// should not be restored as they are.
+var DefaultSkippedSecretTypes = []string{
+	string(core.SecretTypeServiceAccountToken),
+	helmReleaseSecretType,
+	string(core.SecretTypeBootstrapToken),
+}
+
+// SecretPolicy decides how the Secrets are sanitized. The zero policy keeps all the Secrets.
+type SecretPolicy struct {
+	Mode SecretMode
+	// SkipTypes are the types of the Secrets that are skipped whatever the mode is
+	SkipTypes []string
+}
+
+func (p SecretPolicy) Validate() error {
+	switch p.Mode {
+	case "", SecretModeKeep, SecretModeRedact, SecretModeSkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown secret mode %q, allowed values: keep, redact, skip", p.Mode)
+	}
+}
+
+type secretSanitizer struct {
+	policy SecretPolicy
+}
+
+func newSecretSanitizer(policy SecretPolicy) Sanitizer {
+	return secretSanitizer{policy: policy}
+}
+
+// Sanitize returns nil if the Secret is skipped by the policy.
+func (s secretSanitizer) Sanitize(in map[string]any) (map[string]any, error) {
+	in = s.policy.Apply(in)
+	if in == nil {
+		return nil, nil
+	}
+	return newMetadataSanitizer().Sanitize(in)
+}
+
+// Apply redacts the values of a core Secret in place, or returns nil if the Secret is skipped. The other
+// objects are returned as is. It is applied by the Secret sanitizer, and must be applied on its own when
+// the objects are not sanitized so that the policy is never bypassed.
+func (p SecretPolicy) Apply(in map[string]any) map[string]any {
+	apiVersion, _, _ := unstructured.NestedString(in, "apiVersion")
+	kind, _, _ := unstructured.NestedString(in, "kind")
+	if apiVersion != "v1" || kind != "Secret" {
+		return in
+	}
+	if p.skip(in) {
+		return nil
+	}
+	if p.Mode == SecretModeRedact {
+		redactValues(in, "data")
+		redactValues(in, "stringData")
+		// the last applied configuration holds the values of the Secret too
+		if meta, ok := in["metadata"].(map[string]any); ok {
+			if annotations, ok := meta["annotations"].(map[string]any); ok {
+				delete(annotations, core.LastAppliedConfigAnnotation)
+			}
+		}
+	}
+	return in
+}
+
+func (p SecretPolicy) skip(in map[string]any) bool {
+	if p.Mode == SecretModeSkip {
+		return true
+	}
+	secretType, _, _ := unstructured.NestedString(in, "type")
+	if secretType == "" {
+		secretType = string(core.SecretTypeOpaque)
+	}
+	for _, t := range p.SkipTypes {
+		if t == secretType {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValues(in map[string]any, field string) {
+	values, ok := in[field].(map[string]any)
+	if !ok {
+		return
+	}
+	for k := range values {
+		values[k] = ""
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sanitizers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newSecret(secretType string) map[string]any {
+	obj := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":            "db",
+			"namespace":       "demo",
+			"resourceVersion": "42",
+			"annotations": map[string]any{
+				"kubectl.kubernetes.io/last-applied-configuration": `{"data":{"password":"czNjcjN0"}}`,
+				"team": "db",
+			},
+		},
+		"data": map[string]any{
+			"password": "czNjcjN0",
+		},
+	}
+	if secretType != "" {
+		obj["type"] = secretType
+	}
+	return obj
+}
+
+func Test_secretSanitizer(t *testing.T) {
+	defaults := SecretPolicy{Mode: SecretModeKeep, SkipTypes: DefaultSkippedSecretTypes}
+	tests := []struct {
+		name     string
+		policy   SecretPolicy
+		in       map[string]any
+		skipped  bool
+		password string
+	}{
+		{name: "zero policy keeps every secret", in: newSecret("kubernetes.io/service-account-token"), password: "czNjcjN0"},
+		{name: "keep", policy: defaults, in: newSecret(""), password: "czNjcjN0"},
+		{name: "redact", policy: SecretPolicy{Mode: SecretModeRedact}, in: newSecret("Opaque"), password: ""},
+		{name: "skip", policy: SecretPolicy{Mode: SecretModeSkip}, in: newSecret("Opaque"), skipped: true},
+		{name: "service account token", policy: defaults, in: newSecret("kubernetes.io/service-account-token"), skipped: true},
+		{name: "helm release", policy: defaults, in: newSecret("helm.sh/release.v1"), skipped: true},
+		{name: "bootstrap token", policy: defaults, in: newSecret("bootstrap.kubernetes.io/token"), skipped: true},
+		{name: "skipped opaque type", policy: SecretPolicy{SkipTypes: []string{"Opaque"}}, in: newSecret(""), skipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := NewSanitizer("Secret", tt.policy).Sanitize(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.skipped {
+				if out != nil {
+					t.Errorf("Sanitize() = %v, want the secret to be skipped", out)
+				}
+				return
+			}
+			data := out["data"].(map[string]any)
+			if !reflect.DeepEqual(data, map[string]any{"password": tt.password}) {
+				t.Errorf("data = %v, want password %q", data, tt.password)
+			}
+			meta := out["metadata"].(map[string]any)
+			if _, ok := meta["resourceVersion"]; ok {
+				t.Error("the metadata of the secret has not been sanitized")
+			}
+			annotations := meta["annotations"].(map[string]any)
+			_, applied := annotations["kubectl.kubernetes.io/last-applied-configuration"]
+			if applied != (tt.policy.Mode != SecretModeRedact) || annotations["team"] != "db" {
+				t.Errorf("annotations = %v", annotations)
+			}
+		})
+	}
+}
+
+func Test_secretSanitizer_otherGroup(t *testing.T) {
+	in := newSecret("Opaque")
+	in["apiVersion"] = "example.com/v1"
+	out, err := NewSanitizer("Secret", SecretPolicy{Mode: SecretModeSkip}).Sanitize(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil {
+		t.Error("a Secret of another group has been skipped")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// SigningKeySecretKey is the key of the signing Secret that holds the PEM encoded PKCS #8 ed25519 private key
+// (i.e. generated by openssl genpkey -algorithm ed25519).
+const SigningKeySecretKey = "signing.key"
+
+// addSigningFlags registers the flags of the Secret that holds the key used to sign the index of a dump.
+func (opt *options) addSigningFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opt.signingSecret.Name, "signing-secret-name", opt.signingSecret.Name, "Specify the name of the Secret that holds the ed25519 key used to sign the index of the dump in its "+SigningKeySecretKey+" key.")
+	cmd.Flags().StringVar(&opt.signingSecret.Namespace, "signing-secret-namespace", opt.signingSecret.Namespace, "Specify the namespace of the signing Secret (defaults to the namespace of the Backup/Restore Session, or default).")
+}
+
+// loadSigningKey reads the signing key from the signing Secret. It returns nil if no Secret has been specified.
+func (opt *options) loadSigningKey() (ed25519.PrivateKey, error) {
+	if opt.signingSecret.Name == "" {
+		return nil, nil
+	}
+	data, err := opt.readSecretKey(opt.signingSecret, SigningKeySecretKey)
+	if err != nil {
+		return nil, err
+	}
+	return parseSigningKey(data)
+}
+
+func parseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is a %T, not an ed25519 key", key)
+	}
+	return privateKey, nil
+}
+
+// readPublicKey reads a PEM encoded PKIX ed25519 public key (i.e. generated by openssl pkey -pubout).
+func readPublicKey(fileName string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is a %T, not an ed25519 key", key)
+	}
+	return publicKey, nil
+}
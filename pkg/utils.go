@@ -17,13 +17,22 @@ limitations under the License.
 package pkg
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	stash "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/kubedump/pkg/manager"
+	"stash.appscode.dev/kubedump/pkg/sanitizers"
 
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	kmapi "kmodules.xyz/client-go/api/v1"
@@ -38,19 +47,138 @@ type options struct {
 	outputDir         string
 	storageSecret     kmapi.ObjectReference
 
-	sanitize          bool
-	config            *rest.Config
-	dataDir           string
-	selector          string
-	includeDependants bool
-	ignoreGroupKinds  []string
+	sanitize                   bool
+	config                     *rest.Config
+	dataDir                    string
+	selector                   string
+	includeDependants          bool
+	ignoreGroupKinds           []string
+	includeResources           []string
+	excludeResources           []string
+	includeNamespaces          []string
+	excludeNamespaces          []string
+	namespaceSelector          string
+	targetNamespaces           []string
+	includeClusterDependencies bool
+	includeReferences          bool
+	appSelector                string
+	concurrency                int
+	strict                     bool
+	consistency                string
+	secretMode                 string
+	skipSecretTypes            []string
+	signingSecret              kmapi.ObjectReference
+	signingKey                 ed25519.PrivateKey
+	encryptionSecret           kmapi.ObjectReference
+	encryptionKey              []byte
+	namespaceMapping           map[string]string
+	conflictPolicy             manager.ConflictPolicy
+	dryRun                     bool
+	restoreFilter              manager.RestoreFilter
+	fromWatch                  bool
+	pointInTime                string
 
 	invokerKind string
 	invokerName string
 	targetRef   v1beta1.TargetRef
 
-	setupOptions  restic.SetupOptions
-	backupOptions restic.BackupOptions
+	setupOptions   restic.SetupOptions
+	backupOptions  restic.BackupOptions
+	restoreOptions restic.RestoreOptions
+}
+
+// addManagerFlags registers the flags of the backup manager options that are shared by every command that dumps resources.
+func (opt *options) addManagerFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&opt.sanitize, "sanitize", true, "Specify whether to remove the decorators from the resource YAML (default is true)")
+	cmd.Flags().StringVar(&opt.selector, "label-selector", "", "Specify a label selector to filter the resources.")
+	cmd.Flags().BoolVar(&opt.includeDependants, "include-dependants", false, "Specify whether to backup the dependants object along with their parent.")
+	cmd.Flags().StringSliceVar(&opt.ignoreGroupKinds, "ignore-groupkinds", opt.ignoreGroupKinds, "Specify the groupkinds to ignore.")
+	cmd.Flags().StringSliceVar(&opt.includeResources, "include-resources", opt.includeResources, "Specify the resources to backup as <resource>[.<group>], <group>/<resource> or <group>/<version>/<resource> wildcard patterns (i.e. apps/*, batch/*). The resource can also be matched by kind and the core group is written as core.")
+	cmd.Flags().StringSliceVar(&opt.excludeResources, "exclude-resources", opt.excludeResources, "Specify the resources to skip using the same wildcard patterns as --include-resources (i.e. *.metrics.k8s.io).")
+	cmd.Flags().StringSliceVar(&opt.includeNamespaces, "include-namespaces", opt.includeNamespaces, "Specify the glob patterns of the namespaces to backup when backing up the whole cluster. Cluster scoped resources are backed up regardless.")
+	cmd.Flags().StringSliceVar(&opt.excludeNamespaces, "exclude-namespaces", opt.excludeNamespaces, "Specify the glob patterns of the namespaces to skip when backing up the whole cluster (i.e. kube-system,ci-*).")
+	cmd.Flags().StringVar(&opt.namespaceSelector, "namespace-selector", opt.namespaceSelector, "Specify the label selector of the namespaces to backup when backing up the whole cluster (i.e. backup=true).")
+	cmd.Flags().StringSliceVar(&opt.targetNamespaces, "target-namespaces", opt.targetNamespaces, "Specify the namespaces to backup together in a single snapshot. Their namespaced resources and Namespace objects are backed up.")
+	cmd.Flags().BoolVar(&opt.includeClusterDependencies, "include-cluster-dependencies", opt.includeClusterDependencies, "Specify whether to backup the cluster scoped objects that the backed up objects of a namespace depend on (i.e. Namespace, CRDs, ClusterRoles, PersistentVolumes, StorageClasses, PriorityClasses and IngressClasses).")
+	cmd.Flags().BoolVar(&opt.includeReferences, "include-references", opt.includeReferences, "Specify whether to backup the objects referenced by the pod specs of an application (i.e. ConfigMaps, Secrets, PVCs and ServiceAccount) along with the Services, PodDisruptionBudgets and HorizontalPodAutoscalers targeting it.")
+	cmd.Flags().StringVar(&opt.appSelector, "app-selector", opt.appSelector, "Specify the label selector of the components of an application without a common owner (i.e. app.kubernetes.io/instance=shop). The components are collected from the target namespace, or from the whole cluster if it is empty.")
+	cmd.Flags().IntVar(&opt.concurrency, "concurrency", 1, "Specify the maximum number of resources to list in parallel.")
+	cmd.Flags().BoolVar(&opt.strict, "strict", false, "Specify whether to fail if any API group can't be discovered or any resource can't be listed. Otherwise, they are skipped with a warning.")
+	cmd.Flags().StringVar(&opt.consistency, "consistency", opt.consistency, "Specify NotOlderThan or Exact to list every resource at the resource version of the start of the dump. The objects modified during the dump are listed in the report.")
+	cmd.Flags().StringVar(&opt.secretMode, "secret-mode", string(sanitizers.SecretModeKeep), "Specify whether to keep the Secrets, redact the values of their data while keeping the keys, or skip them. It applies even if the resources are not sanitized. Allowed values: keep, redact, skip")
+	cmd.Flags().StringSliceVar(&opt.skipSecretTypes, "skip-secret-types", sanitizers.DefaultSkippedSecretTypes, "Specify the types of the Secrets to skip, even if the resources are not sanitized (keep empty to dump the Secrets of any type).")
+}
+
+func (opt *options) managerOptions(targetRef v1beta1.TargetRef) manager.BackupOptions {
+	return manager.BackupOptions{
+		Config:                     opt.config,
+		Sanitize:                   opt.sanitize,
+		DataDir:                    opt.dataDir,
+		Target:                     targetRef,
+		Selector:                   opt.selector,
+		IncludeDependants:          opt.includeDependants,
+		IgnoreGroupKinds:           opt.ignoreGroupKinds,
+		IncludeResources:           opt.includeResources,
+		ExcludeResources:           opt.excludeResources,
+		IncludeNamespaces:          opt.includeNamespaces,
+		ExcludeNamespaces:          opt.excludeNamespaces,
+		NamespaceSelector:          opt.namespaceSelector,
+		Namespaces:                 opt.targetNamespaces,
+		IncludeClusterDependencies: opt.includeClusterDependencies,
+		IncludeReferences:          opt.includeReferences,
+		AppSelector:                opt.appSelector,
+		Storage:                    manager.NewFileWriter(),
+		Concurrency:                opt.concurrency,
+		Strict:                     opt.strict,
+		Consistency:                opt.consistency,
+		SigningKey:                 opt.signingKey,
+		EncryptionKey:              opt.encryptionKey,
+		SecretPolicy: sanitizers.SecretPolicy{
+			Mode:      sanitizers.SecretMode(opt.secretMode),
+			SkipTypes: opt.skipSecretTypes,
+		},
+	}
+}
+
+// splitList splits a comma separated list and drops the empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// readSecretKey reads a key of the Secret. The Secret is looked up in the namespace of the Backup/Restore
+// Session, or in the default namespace, if its namespace is not specified.
+func (opt *options) readSecretKey(ref kmapi.ObjectReference, key string) ([]byte, error) {
+	kubeClient := opt.kubeClient
+	if kubeClient == nil {
+		var err error
+		kubeClient, err = kubernetes.NewForConfig(opt.config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = opt.namespace
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", namespace, ref.Name, key)
+	}
+	return data, nil
 }
 
 func clearDir(dir string) error {
@@ -59,3 +187,14 @@ func clearDir(dir string) error {
 	}
 	return os.MkdirAll(dir, os.ModePerm)
 }
+
+func writeJSONFile(fileName string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, data, 0o644)
+}
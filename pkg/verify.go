@@ -0,0 +1,97 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"stash.appscode.dev/kubedump/pkg/manager"
+
+	"github.com/spf13/cobra"
+	"gomodules.xyz/flags"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+func NewCmdVerify() *cobra.Command {
+	var (
+		masterURL      string
+		kubeconfigPath string
+		publicKeyFile  string
+		opt            options
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verifies a dumped or restored tree against its index",
+		Long: "Checks that the objects of a dumped or restored tree match the " + manager.IndexFileName + " at its root. " +
+			"If a public key or the signing Secret is given, the signature of the index is verified too.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "data-dir")
+
+			var (
+				key ed25519.PublicKey
+				err error
+			)
+			switch {
+			case publicKeyFile != "":
+				key, err = readPublicKey(publicKeyFile)
+			case opt.signingSecret.Name != "":
+				opt.config, err = clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+				if err != nil {
+					return err
+				}
+				var privateKey ed25519.PrivateKey
+				privateKey, err = opt.loadSigningKey()
+				if err == nil {
+					key = privateKey.Public().(ed25519.PublicKey)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			result, err := manager.VerifyIndex(opt.dataDir, key)
+			if err != nil {
+				return err
+			}
+			data, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(data))
+			if !result.Valid() {
+				return errors.New("the tree does not match its index")
+			}
+			if key == nil {
+				klog.Warningln("The signature of the index has not been verified as no key has been given")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.dataDir, "data-dir", opt.dataDir, "Directory of the dumped or restored tree")
+	cmd.Flags().StringVar(&publicKeyFile, "public-key", publicKeyFile, "Specify the PEM encoded ed25519 public key used to verify the signature of the index.")
+	opt.addSigningFlags(cmd)
+
+	return cmd
+}
@@ -0,0 +1,175 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Free Trial License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Free-Trial-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/kubedump/pkg/manager"
+
+	"github.com/spf13/cobra"
+	license "go.bytebuilders.dev/license-verifier/kubernetes"
+	"gomodules.xyz/flags"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+func NewCmdWatch() *cobra.Command {
+	var (
+		masterURL          string
+		kubeconfigPath     string
+		compactionInterval = 15 * time.Minute
+		opt                = options{
+			setupOptions: restic.SetupOptions{
+				ScratchDir:  restic.DefaultScratchDir,
+				EnableCache: false,
+			},
+			backupOptions: restic.BackupOptions{
+				Host: restic.DefaultHost,
+			},
+		}
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Continuously backs up Kubernetes resources",
+		Long: "Watches the Kubernetes resources and journals every change of them. The journal is periodically pushed as a " +
+			"restic snapshot along with the full dump compacted at the previous snapshot. Use restore with --from-watch and " +
+			"--point-in-time to restore the resources as they were at any time covered by the journal of a snapshot.",
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags.EnsureRequiredFlags(cmd, "provider", "storage-secret-name", "storage-secret-namespace")
+
+			config, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfigPath)
+			if err != nil {
+				return err
+			}
+			opt.config = config
+
+			opt.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			err = license.CheckLicenseEndpoint(opt.config, licenseApiService, SupportedProducts)
+			if err != nil {
+				return err
+			}
+			opt.setupOptions.StorageSecret, err = opt.kubeClient.CoreV1().Secrets(opt.storageSecret.Namespace).Get(context.TODO(), opt.storageSecret.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			opt.encryptionKey, err = opt.loadEncryptionKey()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			return opt.watchResources(ctx, compactionInterval)
+		},
+	}
+	cmd.Flags().StringVar(&masterURL, "master", masterURL, "The address of the Kubernetes API server (overrides any value in kubeconfig)")
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "Path to kubeconfig file with authorization information (the master location is set by the master flag).")
+	cmd.Flags().StringVar(&opt.namespace, "namespace", "default", "Namespace where the watcher is running")
+	cmd.Flags().StringVar(&opt.storageSecret.Name, "storage-secret-name", opt.storageSecret.Name, "Name of the storage secret")
+	cmd.Flags().StringVar(&opt.storageSecret.Namespace, "storage-secret-namespace", opt.storageSecret.Namespace, "Namespace of the storage secret")
+
+	cmd.Flags().StringVar(&opt.setupOptions.Provider, "provider", opt.setupOptions.Provider, "Backend provider (i.e. gcs, s3, azure etc)")
+	cmd.Flags().StringVar(&opt.setupOptions.Bucket, "bucket", opt.setupOptions.Bucket, "Name of the cloud bucket/container (keep empty for local backend)")
+	cmd.Flags().StringVar(&opt.setupOptions.Endpoint, "endpoint", opt.setupOptions.Endpoint, "Endpoint for s3/s3 compatible backend or REST server URL")
+	cmd.Flags().BoolVar(&opt.setupOptions.InsecureTLS, "insecure-tls", opt.setupOptions.InsecureTLS, "InsecureTLS for TLS secure s3/s3 compatible backend")
+	cmd.Flags().StringVar(&opt.setupOptions.Region, "region", opt.setupOptions.Region, "Region for s3/s3 compatible backend")
+	cmd.Flags().StringVar(&opt.setupOptions.Path, "path", opt.setupOptions.Path, "Directory inside the bucket where backup will be stored")
+	cmd.Flags().StringVar(&opt.setupOptions.ScratchDir, "scratch-dir", opt.setupOptions.ScratchDir, "Directory where the journal and the compacted dump are stored")
+	cmd.Flags().BoolVar(&opt.setupOptions.EnableCache, "enable-cache", opt.setupOptions.EnableCache, "Specify whether to enable caching for restic")
+	cmd.Flags().Int64Var(&opt.setupOptions.MaxConnections, "max-connections", opt.setupOptions.MaxConnections, "Specify maximum concurrent connections for GCS, Azure and B2 backend")
+	cmd.Flags().StringVar(&opt.backupOptions.Host, "hostname", opt.backupOptions.Host, "Name of the host machine")
+
+	cmd.Flags().StringVar(&opt.targetRef.Kind, "target-kind", opt.targetRef.Kind, "Kind of the Target (keep empty to watch the whole cluster)")
+	cmd.Flags().StringVar(&opt.targetRef.Name, "target-name", opt.targetRef.Name, "Name of the Target")
+	cmd.Flags().DurationVar(&compactionInterval, "compaction-interval", compactionInterval, "Specify how often the journal is compacted and pushed as a snapshot.")
+
+	opt.addManagerFlags(cmd)
+	opt.addEncryptionFlags(cmd)
+
+	return cmd
+}
+
+func (opt *options) watchResources(ctx context.Context, interval time.Duration) error {
+	resticWrapper, err := restic.NewResticWrapper(opt.setupOptions)
+	if err != nil {
+		return err
+	}
+	if !resticWrapper.RepositoryAlreadyExist() {
+		klog.Infoln("Initializing repository")
+		if err := resticWrapper.InitializeRepository(); err != nil {
+			return err
+		}
+	}
+
+	w := manager.NewWatcher(opt.managerOptions(opt.targetRef), filepath.Join(opt.setupOptions.ScratchDir, "watch"))
+	if err := w.Start(ctx); err != nil {
+		return err
+	}
+	defer w.Close()
+	if warnings := w.Warnings(); len(warnings) > 0 {
+		klog.Warningf("Skipped %d resources that can't be watched", len(warnings))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// push the changes journaled since the last snapshot before exiting
+			return opt.pushSnapshot(w, resticWrapper)
+		case <-ticker.C:
+			// the segments that could not be pushed are kept for the next snapshot
+			if err := opt.pushSnapshot(w, resticWrapper); err != nil {
+				klog.Errorf("Failed to push snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// pushSnapshot pushes the compacted dump along with the pending journal segments, which are then applied to the
+// compacted dump.
+func (opt *options) pushSnapshot(w *manager.Watcher, resticWrapper *restic.ResticWrapper) error {
+	segments, err := w.Compact()
+	if err != nil {
+		return err
+	}
+	opt.backupOptions.BackupPaths = []string{w.SnapshotDir()}
+	output, err := resticWrapper.RunBackup(opt.backupOptions, opt.targetRef)
+	if err != nil {
+		return err
+	}
+	for _, s := range output.BackupTargetStatus.Stats {
+		for _, snap := range s.Snapshots {
+			klog.Infof("Pushed snapshot %s with %d journal segments", snap.Name, len(segments))
+		}
+	}
+	return w.Commit(segments)
+}